@@ -27,4 +27,16 @@ type PerRPCAuth interface {
 // AuthInfo defines the protocol type for authentication
 type AuthInfo interface {
 	AuthType() string
-}
\ No newline at end of file
+}
+
+// TransportSecurityRequirer is implemented by the PerRPCAuth whose metadata must only be sent
+// over connections authenticated by a TransportAuth
+type TransportSecurityRequirer interface {
+	RequireTransportSecurity() bool
+}
+
+// PayloadAuth is implemented by the PerRPCAuth whose metadata depends on the request, e.g. : a
+// signature of the payload. GetPayloadMetadata is called instead of GetMetadata
+type PayloadAuth interface {
+	GetPayloadMetadata(ctx context.Context, servicePath string, payload []byte) (map[string]string, error)
+}
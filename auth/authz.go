@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/HuaTug/My-RPC/codes"
+	"github.com/HuaTug/My-RPC/interceptor"
+	"github.com/HuaTug/My-RPC/log"
+	"github.com/HuaTug/My-RPC/stream"
+)
+
+// PolicyRule allows identities to call methods. Empty Service or Method fields match every service or method
+type PolicyRule struct {
+	Service string `json:"service"` // service name, e.g. : test.Greeter
+	Method  string `json:"method"`  // method name, e.g. : SayHello
+	// names of the allowed identities. A name with a scheme, e.g. : spiffe://example.org/ns/prod/sa/web,
+	// matches the URI SANs, a name prefixed by cn: matches the common name and the other names match
+	// the DNS SANs. A trailing * matches any name with the prefix, and "*" alone matches any authenticated identity
+	Principals []string `json:"principals"`
+}
+
+// Policy declares which identities may call which methods, a call matching no rule is denied
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// LoadPolicy reads a Policy from a json file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// Allowed reports whether the identity may call the method, and the index of the rule allowing it
+func (p *Policy) Allowed(id *Identity, serviceName, method string) (bool, int) {
+	for i, rule := range p.Rules {
+		if rule.match(serviceName, method) && rule.allows(id) {
+			return true, i
+		}
+	}
+	return false, -1
+}
+
+func (r *PolicyRule) match(serviceName, method string) bool {
+	return (r.Service == "" || r.Service == serviceName) && (r.Method == "" || r.Method == method)
+}
+
+func (r *PolicyRule) allows(id *Identity) bool {
+	for _, principal := range r.Principals {
+		if principal == "*" {
+			return true
+		}
+		// a principal is only matched against the field of its type, so that e.g. a common name
+		// spelled like a URI is never taken for a URI SAN
+		var names []string
+		switch {
+		case strings.Contains(principal, "://"):
+			names = id.URIs
+		case strings.HasPrefix(principal, "cn:"):
+			principal = strings.TrimPrefix(principal, "cn:")
+			if id.CommonName != "" {
+				names = []string{id.CommonName}
+			}
+		default:
+			names = id.DNSNames
+		}
+		for _, name := range names {
+			if matchPrincipal(principal, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchPrincipal(principal, name string) bool {
+	if prefix := strings.TrimSuffix(principal, "*"); prefix != principal {
+		return strings.HasPrefix(name, prefix)
+	}
+	return principal == name
+}
+
+// BuildAuthzInterceptor constructs a server interceptor enforcing a Policy on the peer identities.
+// Calls without an authenticated identity fail with codes.ClientCertFail, calls no rule allows
+// fail with codes.PermissionDenied. Every decision is written to the audit log
+func BuildAuthzInterceptor(policy *Policy) interceptor.ServerInterceptor {
+
+	return func(ctx context.Context, req interface{}, handler interceptor.Handler) (interface{}, error) {
+		var serviceName, method string
+		if ss, ok := ctx.Value(stream.ServerStreamKey).(*stream.ServerStream); ok {
+			serviceName, method = ss.ServiceName, ss.Method
+		}
+
+		id, ok := IdentityFromContext(ctx)
+		if !ok {
+			log.Infof("authz audit: denied service=%s method=%s identity=none", serviceName, method)
+			return nil, codes.NewFrameworkError(codes.ClientCertFail, "no authenticated peer identity")
+		}
+
+		allowed, rule := policy.Allowed(id, serviceName, method)
+		if !allowed {
+			log.Infof("authz audit: denied service=%s method=%s identity=%s", serviceName, method, id)
+			return nil, codes.NewFrameworkError(codes.PermissionDenied, "identity "+id.String()+" is not allowed to call "+serviceName+"/"+method)
+		}
+
+		log.Infof("authz audit: allowed service=%s method=%s identity=%s rule=%d", serviceName, method, id, rule)
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func TestPolicyPrincipalTypes(t *testing.T) {
+	policy := &Policy{Rules: []PolicyRule{{
+		Service:    "test.Greeter",
+		Principals: []string{"spiffe://example.org/web", "cn:admin", "api.example.org"},
+	}}}
+
+	tests := []struct {
+		name string
+		id   *Identity
+		want bool
+	}{
+		{"uri san", &Identity{URIs: []string{"spiffe://example.org/web"}}, true},
+		{"common name", &Identity{CommonName: "admin"}, true},
+		{"dns san", &Identity{DNSNames: []string{"api.example.org"}}, true},
+		{"uri in common name", &Identity{CommonName: "spiffe://example.org/web"}, false},
+		{"dns in common name", &Identity{CommonName: "api.example.org"}, false},
+		{"common name in dns san", &Identity{DNSNames: []string{"admin"}}, false},
+	}
+	for _, tt := range tests {
+		if got, _ := policy.Allowed(tt.id, "test.Greeter", "SayHello"); got != tt.want {
+			t.Errorf("%s: Allowed() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPeerIdentityRequiresVerifiedChain(t *testing.T) {
+	uri, _ := url.Parse("spiffe://example.org/web")
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "web"}, URIs: []*url.URL{uri}}
+
+	unverified := TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}}
+	if id := unverified.PeerIdentity(); id != nil {
+		t.Fatalf("PeerIdentity() of an unverified certificate = %v, want nil", id)
+	}
+
+	verified := TLSInfo{State: tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}}
+	if id := verified.PeerIdentity(); id == nil || id.SPIFFEID != "spiffe://example.org/web" {
+		t.Fatalf("PeerIdentity() = %v, want spiffe://example.org/web", id)
+	}
+}
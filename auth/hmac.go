@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codes"
+	"github.com/HuaTug/My-RPC/interceptor"
+	"github.com/HuaTug/My-RPC/metadata"
+	"github.com/HuaTug/My-RPC/stream"
+)
+
+// SignatureKey is the metadata key carrying the HMAC signature of a request :
+// keyId=<key ID>,ts=<unix ms>,nonce=<nonce>,sig=<base64 signature>
+const SignatureKey = "gorpc-signature"
+
+// signatureScheme prefixes the signed string, so that a signature is never valid for another scheme
+const signatureScheme = "GORPC-HMAC-SHA256"
+
+const (
+	defaultSignatureWindow = 5 * time.Minute
+	defaultMaxNonces       = 100000
+)
+
+type hmacAuth struct {
+	keyID  string
+	secret []byte
+}
+
+// NewHMAC returns a PerRPCAuth signing the service path, the payload digest, a timestamp and a
+// nonce of every request with the shared secret of keyID
+func NewHMAC(keyID string, secret []byte) PerRPCAuth {
+	return &hmacAuth{keyID: keyID, secret: secret}
+}
+
+func (h *hmacAuth) AuthType() string {
+	return "hmac"
+}
+
+// GetMetadata fails, the signature covers the payload which is only given to GetPayloadMetadata
+func (h *hmacAuth) GetMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return nil, errors.New("hmac signature requires the request payload")
+}
+
+// GetPayloadMetadata signs a request
+func (h *hmacAuth) GetPayloadMetadata(ctx context.Context, servicePath string, payload []byte) (map[string]string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	n := hex.EncodeToString(nonce)
+	sig := signRequest(h.secret, servicePath, payload, ts, n)
+
+	return map[string]string{
+		SignatureKey: fmt.Sprintf("keyId=%s,ts=%s,nonce=%s,sig=%s", h.keyID, ts, n, sig),
+	}, nil
+}
+
+func signRequest(secret []byte, servicePath string, payload []byte, ts string, nonce string) string {
+	digest := sha256.Sum256(payload)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signatureScheme + "\n" + servicePath + "\n" + hex.EncodeToString(digest[:]) + "\n" + ts + "\n" + nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// HMACKeyring holds the active signing keys by key ID. During a rotation the old and the new keys
+// are both active, the old one is removed once no client signs with it anymore
+type HMACKeyring struct {
+	mu   sync.RWMutex
+	keys map[string][]byte
+}
+
+// NewHMACKeyring returns a keyring of the secrets by key ID
+func NewHMACKeyring(keys map[string][]byte) *HMACKeyring {
+	k := &HMACKeyring{keys: make(map[string][]byte, len(keys))}
+	for keyID, secret := range keys {
+		k.keys[keyID] = secret
+	}
+	return k
+}
+
+// Set adds or replaces the secret of a key ID
+func (k *HMACKeyring) Set(keyID string, secret []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[keyID] = secret
+}
+
+// Remove deactivates a key ID
+func (k *HMACKeyring) Remove(keyID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.keys, keyID)
+}
+
+func (k *HMACKeyring) get(keyID string) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	secret, ok := k.keys[keyID]
+	return secret, ok
+}
+
+// HMACVerifierOptions defines the replay protection of the HMAC verification
+type HMACVerifierOptions struct {
+	Window    time.Duration // accepted clock skew of the timestamps, and how long the nonces are remembered, default: 5 min
+	MaxNonces int           // nonces remembered at most, requests are rejected while it is full, default: 100000
+}
+
+type HMACVerifierOption func(*HMACVerifierOptions)
+
+// WithSignatureWindow returns a HMACVerifierOption which sets the value for window
+func WithSignatureWindow(window time.Duration) HMACVerifierOption {
+	return func(o *HMACVerifierOptions) {
+		o.Window = window
+	}
+}
+
+// WithMaxNonces returns a HMACVerifierOption which sets the value for maxNonces
+func WithMaxNonces(maxNonces int) HMACVerifierOption {
+	return func(o *HMACVerifierOptions) {
+		o.MaxNonces = maxNonces
+	}
+}
+
+// BuildHMACInterceptor constructs a server interceptor verifying the HMAC signature of the requests
+// with the keys of the keyring. Requests whose timestamp is outside the window, or whose nonce was
+// already seen within the window, are rejected with codes.ClientCertFail
+func BuildHMACInterceptor(keyring *HMACKeyring, opts ...HMACVerifierOption) interceptor.ServerInterceptor {
+	o := &HMACVerifierOptions{
+		Window:    defaultSignatureWindow,
+		MaxNonces: defaultMaxNonces,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	nonces := newNonceCache(o.MaxNonces)
+
+	return func(ctx context.Context, req interface{}, handler interceptor.Handler) (interface{}, error) {
+		if err := verifySignature(ctx, keyring, nonces, o.Window); err != nil {
+			return nil, codes.NewFrameworkError(codes.ClientCertFail, err.Error())
+		}
+		return handler(ctx, req)
+	}
+}
+
+func verifySignature(ctx context.Context, keyring *HMACKeyring, nonces *nonceCache, window time.Duration) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(SignatureKey)
+	if len(values) == 0 {
+		return errors.New("missing request signature")
+	}
+
+	fields := make(map[string]string, 4)
+	for _, field := range strings.Split(values[0], ",") {
+		if k, v, ok := strings.Cut(field, "="); ok {
+			fields[k] = v
+		}
+	}
+	keyID, ts, nonce, sig := fields["keyId"], fields["ts"], fields["nonce"], fields["sig"]
+	if ts == "" || nonce == "" || sig == "" {
+		return errors.New("malformed request signature")
+	}
+
+	secret, ok := keyring.get(keyID)
+	if !ok {
+		return fmt.Errorf("unknown signing key %q", keyID)
+	}
+
+	ss, ok := ctx.Value(stream.ServerStreamKey).(*stream.ServerStream)
+	if !ok {
+		return errors.New("request payload unavailable")
+	}
+	servicePath := "/" + ss.ServiceName + "/" + ss.Method
+	if !hmac.Equal([]byte(sig), []byte(signRequest(secret, servicePath, ss.Payload, ts, nonce))) {
+		return errors.New("invalid request signature")
+	}
+
+	ms, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("malformed request signature")
+	}
+	signedAt := time.UnixMilli(ms)
+	if skew := time.Since(signedAt); skew > window || skew < -window {
+		return errors.New("request signature expired")
+	}
+
+	// a nonce is remembered as long as its timestamp is within the window
+	return nonces.add(keyID+"/"+nonce, signedAt.Add(window))
+}
+
+var (
+	errReplayed       = errors.New("request replayed")
+	errNonceCacheFull = errors.New("too many signed requests, nonce cache full")
+)
+
+// nonceCache remembers the nonces until their expiry, it holds maxNonces of them at most
+type nonceCache struct {
+	maxNonces int
+
+	mu        sync.Mutex
+	expires   map[string]time.Time
+	lastSweep time.Time
+}
+
+func newNonceCache(maxNonces int) *nonceCache {
+	return &nonceCache{
+		maxNonces: maxNonces,
+		expires:   make(map[string]time.Time),
+	}
+}
+
+// add records a nonce, it fails when the nonce is already known or the cache is full
+func (c *nonceCache) add(nonce string, expires time.Time) error {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if exp, ok := c.expires[nonce]; ok && now.Before(exp) {
+		return errReplayed
+	}
+
+	if len(c.expires) >= c.maxNonces && now.Sub(c.lastSweep) >= time.Second {
+		c.lastSweep = now
+		for n, exp := range c.expires {
+			if !now.Before(exp) {
+				delete(c.expires, n)
+			}
+		}
+	}
+	// forgetting unexpired nonces would let their requests be replayed
+	if len(c.expires) >= c.maxNonces {
+		return errNonceCacheFull
+	}
+
+	c.expires[nonce] = expires
+	return nil
+}
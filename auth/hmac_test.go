@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codes"
+	"github.com/HuaTug/My-RPC/metadata"
+	"github.com/HuaTug/My-RPC/stream"
+)
+
+// signedContext returns the context of a request to /test.Greeter/SayHello carrying a signature
+func signedContext(payload []byte, signature string) context.Context {
+	ctx, ss := stream.NewServerStream(context.Background())
+	ss.WithServiceName("test.Greeter").WithMethod("SayHello").WithPayload(payload)
+	return metadata.NewIncomingContext(ctx, metadata.MD{SignatureKey: {signature}})
+}
+
+// signature signs a request the way a client of keyID does, at the given time
+func signature(keyID string, secret []byte, servicePath string, payload []byte, signedAt time.Time, nonce string) string {
+	ts := strconv.FormatInt(signedAt.UnixMilli(), 10)
+	return fmt.Sprintf("keyId=%s,ts=%s,nonce=%s,sig=%s", keyID, ts, nonce, signRequest(secret, servicePath, payload, ts, nonce))
+}
+
+func TestVerifySignature(t *testing.T) {
+	keyring := NewHMACKeyring(map[string][]byte{"k1": []byte("secret")})
+	payload := []byte("hello")
+	const path = "/test.Greeter/SayHello"
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		ok   bool
+	}{
+		{"valid", signedContext(payload, signature("k1", []byte("secret"), path, payload, now, "n1")), true},
+		{"tampered payload", signedContext([]byte("hellO"), signature("k1", []byte("secret"), path, payload, now, "n2")), false},
+		{"other service path", signedContext(payload, signature("k1", []byte("secret"), "/test.Greeter/Delete", payload, now, "n3")), false},
+		{"wrong secret", signedContext(payload, signature("k1", []byte("other"), path, payload, now, "n4")), false},
+		{"unknown key", signedContext(payload, signature("k2", []byte("secret"), path, payload, now, "n5")), false},
+		{"too old", signedContext(payload, signature("k1", []byte("secret"), path, payload, now.Add(-2*time.Minute), "n6")), false},
+		{"too far ahead", signedContext(payload, signature("k1", []byte("secret"), path, payload, now.Add(2*time.Minute), "n7")), false},
+		{"missing", metadata.NewIncomingContext(context.Background(), metadata.MD{}), false},
+		{"malformed", signedContext(payload, "keyId=k1,sig=abc"), false},
+	}
+	for _, tt := range tests {
+		err := verifySignature(tt.ctx, keyring, newNonceCache(10), time.Minute)
+		if tt.ok && err != nil {
+			t.Errorf("%s: verifySignature() = %v, want nil", tt.name, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: verifySignature() accepted the request", tt.name)
+		}
+	}
+}
+
+func TestHMACInterceptor(t *testing.T) {
+	secret := []byte("secret")
+	payload := []byte("hello")
+	intercept := BuildHMACInterceptor(NewHMACKeyring(map[string][]byte{"k1": secret}))
+
+	md, err := NewHMAC("k1", secret).(PayloadAuth).GetPayloadMetadata(context.Background(), "/test.Greeter/SayHello", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := signedContext(payload, md[SignatureKey])
+
+	called := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		called++
+		return nil, nil
+	}
+	if _, err := intercept(ctx, nil, handler); err != nil || called != 1 {
+		t.Fatalf("intercept() = %v, handler called %d times, want a verified request", err, called)
+	}
+
+	// the same signed request sent again within the window
+	if _, err := intercept(ctx, nil, handler); codes.CodeOf(err) != codes.Unauthenticated || called != 1 {
+		t.Errorf("replay intercept() = %v, handler called %d times, want code %v", err, called, codes.Unauthenticated)
+	}
+}
+
+func TestHMACKeyRotation(t *testing.T) {
+	keyring := NewHMACKeyring(map[string][]byte{"old": []byte("old secret")})
+	payload := []byte("hello")
+	const path = "/test.Greeter/SayHello"
+	verify := func(keyID string, secret []byte, nonce string) error {
+		ctx := signedContext(payload, signature(keyID, secret, path, payload, time.Now(), nonce))
+		return verifySignature(ctx, keyring, newNonceCache(10), time.Minute)
+	}
+
+	if err := verify("new", []byte("new secret"), "n1"); err == nil {
+		t.Error("new key accepted before being added")
+	}
+
+	// during the rotation both keys are active
+	keyring.Set("new", []byte("new secret"))
+	if err := verify("old", []byte("old secret"), "n2"); err != nil {
+		t.Errorf("old key rejected during the rotation: %v", err)
+	}
+	if err := verify("new", []byte("new secret"), "n3"); err != nil {
+		t.Errorf("new key rejected during the rotation: %v", err)
+	}
+
+	keyring.Remove("old")
+	if err := verify("old", []byte("old secret"), "n4"); err == nil {
+		t.Error("old key accepted once removed")
+	}
+	if err := verify("new", []byte("new secret"), "n5"); err != nil {
+		t.Errorf("new key rejected once the rotation is done: %v", err)
+	}
+
+	// replacing a secret invalidates the signatures of the previous one
+	keyring.Set("new", []byte("newer secret"))
+	if err := verify("new", []byte("new secret"), "n6"); err == nil {
+		t.Error("replaced secret accepted")
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	c := newNonceCache(2)
+	now := time.Now()
+
+	if err := c.add("k1/a", now.Add(time.Minute)); err != nil {
+		t.Fatalf("add() = %v, want nil", err)
+	}
+	if err := c.add("k1/a", now.Add(time.Minute)); err != errReplayed {
+		t.Errorf("add() of a known nonce = %v, want %v", err, errReplayed)
+	}
+	// the same nonce of another key is another request
+	if err := c.add("k2/a", now.Add(time.Minute)); err != nil {
+		t.Errorf("add() of the nonce of another key = %v, want nil", err)
+	}
+
+	// the cache is full of unexpired nonces, forgetting one would allow its replay
+	if err := c.add("k1/b", now.Add(time.Minute)); err != errNonceCacheFull {
+		t.Errorf("add() to a full cache = %v, want %v", err, errNonceCacheFull)
+	}
+
+	// expired nonces are swept to make room
+	c = newNonceCache(1)
+	if err := c.add("k1/a", now.Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.add("k1/b", now.Add(time.Minute)); err != nil {
+		t.Errorf("add() once the nonces expired = %v, want nil", err)
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// Identity describes a peer authenticated by its certificate
+type Identity struct {
+	Subject    string   // distinguished name of the certificate subject
+	CommonName string   // common name of the certificate subject
+	DNSNames   []string // DNS subject alternative names
+	URIs       []string // URI subject alternative names
+	SPIFFEID   string   // the spiffe:// URI SAN, empty when the certificate has none
+}
+
+// IdentityInfo is implemented by the AuthInfo which identify the peer
+type IdentityInfo interface {
+	PeerIdentity() *Identity
+}
+
+// IdentityFromCertificate extracts the Identity of a certificate
+func IdentityFromCertificate(cert *x509.Certificate) *Identity {
+	id := &Identity{
+		Subject:    cert.Subject.String(),
+		CommonName: cert.Subject.CommonName,
+		DNSNames:   cert.DNSNames,
+	}
+
+	for _, uri := range cert.URIs {
+		id.URIs = append(id.URIs, uri.String())
+		if uri.Scheme == "spiffe" && id.SPIFFEID == "" {
+			id.SPIFFEID = uri.String()
+		}
+	}
+
+	return id
+}
+
+// String returns the most specific name of the peer, for logs
+func (id *Identity) String() string {
+	if id.SPIFFEID != "" {
+		return id.SPIFFEID
+	}
+	if id.CommonName != "" {
+		return id.CommonName
+	}
+	return id.Subject
+}
+
+// PeerIdentity returns the identity of the verified peer certificate, nil when the peer sent none
+// or its certificate was not verified against a CA, e.g. : with tls.RequireAnyClientCert
+func (t TLSInfo) PeerIdentity() *Identity {
+	if len(t.State.VerifiedChains) == 0 || len(t.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return IdentityFromCertificate(t.State.VerifiedChains[0][0])
+}
+
+// IdentityFromContext returns the Identity of the peer of a request, ok is false when the
+// connection did not authenticate the peer
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	info, ok := AuthInfoFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	ii, ok := info.(IdentityInfo)
+	if !ok {
+		return nil, false
+	}
+
+	id := ii.PeerIdentity()
+	return id, id != nil
+}
+
+// NewServerMutualTLSFromFile returns a server TransportAuth presenting the certificate of certFile
+// and keyFile, which requires the clients to present a certificate issued by the CA bundle of clientCAFile
+func NewServerMutualTLSFromFile(certFile string, keyFile string, clientCAFile string) (TransportAuth, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := loadCertPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}), nil
+}
+
+// NewClientMutualTLSFromFile returns a client TransportAuth like NewClientTLSFromFile, which
+// presents the certificate of certFile and keyFile to the server
+func NewClientMutualTLSFromFile(caFile string, certFile string, keyFile string, serverName string) (TransportAuth, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		ServerName:   serverName,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	return NewTLS(config), nil
+}
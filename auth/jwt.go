@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWT signing algorithms
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+// defaultJWTTTL is the lifetime of the tokens when JWTOptions.TTL is not set
+const defaultJWTTTL = 5 * time.Minute
+
+// Claims holds the claims of a JWT
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+
+	// all the claims of a verified token, the registered ones included
+	Extra map[string]interface{} `json:"-"`
+}
+
+// Audience is the aud claim, a single string or an array of strings
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*a = values
+	return nil
+}
+
+// Contains reports whether aud is one of the audiences
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// JWTOptions defines the claims of the tokens signed by a JWT PerRPCAuth
+type JWTOptions struct {
+	Issuer   string
+	Subject  string
+	KeyID    string                 // kid header, selects the verification key of the server key set
+	TTL      time.Duration          // lifetime of the tokens, default: 5 min
+	Claims   map[string]interface{} // additional claims
+	Audience func(servicePath string) string
+}
+
+type JWTOption func(*JWTOptions)
+
+// WithJWTIssuer returns a JWTOption which sets the iss claim
+func WithJWTIssuer(issuer string) JWTOption {
+	return func(o *JWTOptions) {
+		o.Issuer = issuer
+	}
+}
+
+// WithJWTSubject returns a JWTOption which sets the sub claim
+func WithJWTSubject(subject string) JWTOption {
+	return func(o *JWTOptions) {
+		o.Subject = subject
+	}
+}
+
+// WithJWTKeyID returns a JWTOption which sets the kid header
+func WithJWTKeyID(keyID string) JWTOption {
+	return func(o *JWTOptions) {
+		o.KeyID = keyID
+	}
+}
+
+// WithJWTTTL returns a JWTOption which sets the lifetime of the tokens
+func WithJWTTTL(ttl time.Duration) JWTOption {
+	return func(o *JWTOptions) {
+		o.TTL = ttl
+	}
+}
+
+// WithJWTClaims returns a JWTOption which adds claims to the tokens
+func WithJWTClaims(claims map[string]interface{}) JWTOption {
+	return func(o *JWTOptions) {
+		o.Claims = claims
+	}
+}
+
+// WithJWTAudience returns a JWTOption which sets how the aud claim is derived from the service path
+func WithJWTAudience(audience func(servicePath string) string) JWTOption {
+	return func(o *JWTOptions) {
+		o.Audience = audience
+	}
+}
+
+// ServiceAudience is the default audience of a call : the service name of its service path,
+// e.g. : test.Greeter for /test.Greeter/SayHello
+func ServiceAudience(servicePath string) string {
+	path := strings.TrimPrefix(servicePath, "/")
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+type jwtAuth struct {
+	alg  string
+	key  interface{}
+	opts *JWTOptions
+
+	mu     sync.Mutex
+	tokens map[string]*cachedJWT // by audience
+}
+
+type cachedJWT struct {
+	token   string
+	refresh time.Time // when the token gets too close to its expiry to be sent
+}
+
+// NewJWT returns a PerRPCAuth sending short-lived JWTs, signed with key : a []byte secret for HS256,
+// an *rsa.PrivateKey for RS256 or an *ecdsa.PrivateKey on the P-256 curve for ES256.
+// A token is reused for the calls to the same audience until half of its lifetime is over
+func NewJWT(alg string, key interface{}, opts ...JWTOption) (PerRPCAuth, error) {
+	if err := checkSigningKey(alg, key); err != nil {
+		return nil, err
+	}
+
+	o := &JWTOptions{
+		TTL:      defaultJWTTTL,
+		Audience: ServiceAudience,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return &jwtAuth{
+		alg:    alg,
+		key:    key,
+		opts:   o,
+		tokens: make(map[string]*cachedJWT),
+	}, nil
+}
+
+func (j *jwtAuth) AuthType() string {
+	return "jwt"
+}
+
+// GetMetadata returns the bearer token of the call, uri is the service path of the call
+func (j *jwtAuth) GetMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	var aud string
+	if len(uri) > 0 {
+		aud = j.opts.Audience(uri[0])
+	}
+
+	token, err := j.token(aud)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"authorization": "Bearer " + token,
+	}, nil
+}
+
+func (j *jwtAuth) token(aud string) (string, error) {
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if t, ok := j.tokens[aud]; ok && now.Before(t.refresh) {
+		return t.token, nil
+	}
+
+	claims := make(map[string]interface{}, len(j.opts.Claims)+5)
+	for k, v := range j.opts.Claims {
+		claims[k] = v
+	}
+	if j.opts.Issuer != "" {
+		claims["iss"] = j.opts.Issuer
+	}
+	if j.opts.Subject != "" {
+		claims["sub"] = j.opts.Subject
+	}
+	if aud != "" {
+		claims["aud"] = aud
+	}
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(j.opts.TTL).Unix()
+
+	token, err := signJWT(j.alg, j.key, j.opts.KeyID, claims)
+	if err != nil {
+		return "", err
+	}
+
+	j.tokens[aud] = &cachedJWT{token: token, refresh: now.Add(j.opts.TTL / 2)}
+	return token, nil
+}
+
+func checkSigningKey(alg string, key interface{}) error {
+	var ok bool
+	switch alg {
+	case HS256:
+		var secret []byte
+		secret, ok = key.([]byte)
+		ok = ok && len(secret) > 0
+	case RS256:
+		_, ok = key.(*rsa.PrivateKey)
+	case ES256:
+		var k *ecdsa.PrivateKey
+		k, ok = key.(*ecdsa.PrivateKey)
+		ok = ok && k.Curve.Params().BitSize == 256
+	default:
+		return fmt.Errorf("unsupported jwt algorithm %q", alg)
+	}
+
+	if !ok {
+		return fmt.Errorf("invalid %s signing key %T", alg, key)
+	}
+	return nil
+}
+
+func signJWT(alg string, key interface{}, kid string, claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(&jwtHeader{Alg: alg, Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signingInput))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			return "", err
+		}
+		// JWS encodes the ECDSA signature as the fixed size concatenation of r and s
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+
+	return signingInput + "." + b64(sig), nil
+}
+
+var errInvalidJWT = errors.New("invalid jwt")
+
+// verifyJWTSignature checks the signature of a token with a public key of the key set
+func verifyJWTSignature(alg string, key interface{}, signingInput string, sig []byte) bool {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch k := key.(type) {
+	case []byte:
+		if alg != HS256 {
+			return false
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signingInput))
+		return hmac.Equal(sig, mac.Sum(nil))
+	case *rsa.PublicKey:
+		return alg == RS256 && rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		if alg != ES256 || len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, digest[:], r, s)
+	}
+	return false
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
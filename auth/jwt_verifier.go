@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codes"
+	"github.com/HuaTug/My-RPC/interceptor"
+	"github.com/HuaTug/My-RPC/metadata"
+	"github.com/HuaTug/My-RPC/stream"
+)
+
+// KeySet holds the keys verifying the JWTs, by key ID
+type KeySet struct {
+	keys map[string]interface{} // []byte, *rsa.PublicKey or *ecdsa.PublicKey
+}
+
+// jwk is a JSON Web Key, only the fields of the supported key types are decoded
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// LoadJWKS reads a KeySet from a JWKS json file : {"keys": [{"kty": "RSA", "kid": "...", "n": "...", "e": "..."}]}.
+// RSA, EC P-256 and oct (HS256 secrets) keys are supported
+func LoadJWKS(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{keys: make(map[string]interface{}, len(jwks.Keys))}
+	for i, k := range jwks.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %d: %v", i, err)
+		}
+		ks.keys[k.Kid] = key
+	}
+
+	return ks, nil
+}
+
+// NewKeySet returns a KeySet of the keys by key ID, the keys are []byte secrets, *rsa.PublicKey or *ecdsa.PublicKey
+func NewKeySet(keys map[string]interface{}) *KeySet {
+	ks := &KeySet{keys: make(map[string]interface{}, len(keys))}
+	for kid, key := range keys {
+		ks.keys[kid] = key
+	}
+	return ks
+}
+
+func (k *jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "oct":
+		return decodeB64(k.K)
+	case "RSA":
+		n, err := decodeB64(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeB64(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeB64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeB64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("invalid EC key")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeB64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// Verify checks the signature of a token and returns its claims. The key is the one of the kid
+// header, or any key of the key set when the token has no kid
+func (ks *KeySet) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidJWT
+	}
+
+	headerJSON, err := decodeB64(parts[0])
+	if err != nil {
+		return nil, errInvalidJWT
+	}
+	header := &jwtHeader{}
+	if err := json.Unmarshal(headerJSON, header); err != nil {
+		return nil, errInvalidJWT
+	}
+	sig, err := decodeB64(parts[2])
+	if err != nil {
+		return nil, errInvalidJWT
+	}
+
+	signingInput := parts[0] + "." + parts[1]
+	verified := false
+	if key, ok := ks.keys[header.Kid]; ok {
+		verified = verifyJWTSignature(header.Alg, key, signingInput, sig)
+	} else if header.Kid == "" {
+		for _, key := range ks.keys {
+			if verified = verifyJWTSignature(header.Alg, key, signingInput, sig); verified {
+				break
+			}
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("invalid jwt signature")
+	}
+
+	payload, err := decodeB64(parts[1])
+	if err != nil {
+		return nil, errInvalidJWT
+	}
+	claims := &Claims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, errInvalidJWT
+	}
+	if err := json.Unmarshal(payload, &claims.Extra); err != nil {
+		return nil, errInvalidJWT
+	}
+
+	return claims, nil
+}
+
+// JWTVerifierOptions defines the checks of the claims of the verified tokens
+type JWTVerifierOptions struct {
+	Issuers   []string      // accepted iss claims, empty accepts any issuer
+	Audiences []string      // accepted aud claims, empty accepts the service name of the called method
+	Leeway    time.Duration // tolerated clock skew on exp and nbf, default: 30s
+}
+
+type JWTVerifierOption func(*JWTVerifierOptions)
+
+// WithJWTIssuers returns a JWTVerifierOption which sets the accepted issuers
+func WithJWTIssuers(issuers ...string) JWTVerifierOption {
+	return func(o *JWTVerifierOptions) {
+		o.Issuers = issuers
+	}
+}
+
+// WithJWTAudiences returns a JWTVerifierOption which sets the accepted audiences
+func WithJWTAudiences(audiences ...string) JWTVerifierOption {
+	return func(o *JWTVerifierOptions) {
+		o.Audiences = audiences
+	}
+}
+
+// WithJWTLeeway returns a JWTVerifierOption which sets the tolerated clock skew
+func WithJWTLeeway(leeway time.Duration) JWTVerifierOption {
+	return func(o *JWTVerifierOptions) {
+		o.Leeway = leeway
+	}
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the JWT verified for a request
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// BuildJWTInterceptor constructs a server interceptor verifying the bearer JWT of the requests
+// against a key set : signature, expiry, audience and issuer. The claims are placed into the handler
+// context, requests without a valid token fail with codes.ClientCertFail
+func BuildJWTInterceptor(keys *KeySet, opts ...JWTVerifierOption) interceptor.ServerInterceptor {
+	o := &JWTVerifierOptions{Leeway: 30 * time.Second}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(ctx context.Context, req interface{}, handler interceptor.Handler) (interface{}, error) {
+		var serviceName string
+		if ss, ok := ctx.Value(stream.ServerStreamKey).(*stream.ServerStream); ok {
+			serviceName = ss.ServiceName
+		}
+
+		claims, err := verifyRequestJWT(ctx, keys, o, serviceName)
+		if err != nil {
+			return nil, codes.NewFrameworkError(codes.ClientCertFail, err.Error())
+		}
+
+		return handler(context.WithValue(ctx, claimsKey{}, claims), req)
+	}
+}
+
+func verifyRequestJWT(ctx context.Context, keys *KeySet, o *JWTVerifierOptions, serviceName string) (*Claims, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, fmt.Errorf("missing bearer token")
+	}
+	token := strings.TrimSpace(values[0])
+	if len(token) < 7 || !strings.EqualFold(token[:7], "bearer ") {
+		return nil, fmt.Errorf("missing bearer token")
+	}
+
+	claims, err := keys.Verify(strings.TrimSpace(token[7:]))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(o.Leeway)) {
+		return nil, fmt.Errorf("jwt expired")
+	}
+	if claims.NotBefore != 0 && now.Add(o.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, fmt.Errorf("jwt not valid yet")
+	}
+
+	audiences := o.Audiences
+	if len(audiences) == 0 {
+		audiences = []string{serviceName}
+	}
+	if !containsAny(claims.Audience, audiences) {
+		return nil, fmt.Errorf("jwt audience not accepted")
+	}
+
+	if len(o.Issuers) > 0 && !containsAny(Audience{claims.Issuer}, o.Issuers) {
+		return nil, fmt.Errorf("jwt issuer not accepted")
+	}
+
+	return claims, nil
+}
+
+func containsAny(values Audience, accepted []string) bool {
+	for _, v := range accepted {
+		if values.Contains(v) {
+			return true
+		}
+	}
+	return false
+}
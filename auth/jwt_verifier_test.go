@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codes"
+	"github.com/HuaTug/My-RPC/metadata"
+	"github.com/HuaTug/My-RPC/stream"
+)
+
+var (
+	testSecret = []byte("secret")
+	testRSAKey = mustRSAKey()
+)
+
+func mustRSAKey() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func testKeySet() *KeySet {
+	return NewKeySet(map[string]interface{}{
+		"hs": testSecret,
+		"rs": &testRSAKey.PublicKey,
+	})
+}
+
+// validClaims are accepted for the test.Greeter service of the test issuer
+func validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss": "issuer",
+		"sub": "client",
+		"aud": "test.Greeter",
+		"exp": now.Add(time.Minute).Unix(),
+		"nbf": now.Add(-time.Minute).Unix(),
+	}
+}
+
+func mustSign(t *testing.T, alg string, key interface{}, kid string, claims map[string]interface{}) string {
+	token, err := signJWT(alg, key, kid, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestKeySetVerify(t *testing.T) {
+	hsToken := mustSign(t, HS256, testSecret, "hs", validClaims())
+	rsToken := mustSign(t, RS256, testRSAKey, "rs", validClaims())
+	parts := strings.Split(rsToken, ".")
+
+	otherClaims := validClaims()
+	otherClaims["sub"] = "admin"
+	otherPayload := strings.Split(mustSign(t, HS256, testSecret, "hs", otherClaims), ".")[1]
+
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sig[0] ^= 0xff
+	tamperedSig := b64(sig)
+
+	// the public RSA key used as an HMAC secret
+	pub, err := x509.MarshalPKIXPublicKey(&testRSAKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	none := b64([]byte(`{"alg":"none","kid":"hs"}`)) + "." + parts[1] + "."
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"hs256", hsToken, true},
+		{"rs256", rsToken, true},
+		{"tampered payload", parts[0] + "." + otherPayload + "." + parts[2], false},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + tamperedSig, false},
+		{"hs256 with the rsa key", mustSign(t, HS256, pub, "rs", validClaims()), false},
+		{"alg none", none, false},
+		{"unknown kid", mustSign(t, HS256, testSecret, "other", validClaims()), false},
+		{"malformed", "not.a-jwt", false},
+	}
+	for _, tt := range tests {
+		claims, err := testKeySet().Verify(tt.token)
+		if tt.ok && (err != nil || claims.Subject != "client") {
+			t.Errorf("%s: Verify() = %v, %v, want the claims of the token", tt.name, claims, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: Verify() accepted the token", tt.name)
+		}
+	}
+}
+
+func TestJWTInterceptor(t *testing.T) {
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		claims[key] = value
+		return claims
+	}
+	bearer := func(claims map[string]interface{}) []string {
+		return []string{"Bearer " + mustSign(t, RS256, testRSAKey, "rs", claims)}
+	}
+
+	tests := []struct {
+		name          string
+		authorization []string
+		ok            bool
+	}{
+		{"valid", bearer(validClaims()), true},
+		{"expired", bearer(with("exp", time.Now().Add(-time.Hour).Unix())), false},
+		{"not valid yet", bearer(with("nbf", time.Now().Add(time.Hour).Unix())), false},
+		{"wrong audience", bearer(with("aud", "other.Service")), false},
+		{"wrong issuer", bearer(with("iss", "other")), false},
+		{"missing authorization", nil, false},
+		{"not a bearer token", []string{"Basic " + base64.StdEncoding.EncodeToString([]byte("user:password"))}, false},
+		{"empty bearer", []string{"Bearer "}, false},
+	}
+
+	intercept := BuildJWTInterceptor(testKeySet(), WithJWTIssuers("issuer"))
+	for _, tt := range tests {
+		ctx, ss := stream.NewServerStream(context.Background())
+		ss.WithServiceName("test.Greeter")
+		if tt.authorization != nil {
+			ctx = metadata.NewIncomingContext(ctx, metadata.MD{"authorization": tt.authorization})
+		}
+
+		var claims *Claims
+		_, err := intercept(ctx, nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+			claims, _ = ClaimsFromContext(ctx)
+			return nil, nil
+		})
+
+		if tt.ok {
+			if err != nil || claims == nil || claims.Subject != "client" {
+				t.Errorf("%s: intercept() = %v, claims %v, want the handler called with the claims", tt.name, err, claims)
+			}
+			continue
+		}
+		if codes.CodeOf(err) != codes.Unauthenticated {
+			t.Errorf("%s: intercept() error = %v, want code %v", tt.name, err, codes.Unauthenticated)
+		}
+		if claims != nil {
+			t.Errorf("%s: handler called", tt.name)
+		}
+	}
+}
@@ -7,42 +7,85 @@ import (
 	"github.com/HuaTug/My-RPC/codes"
 	"github.com/HuaTug/My-RPC/interceptor"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 )
 
+// tokenRefreshMargin is how long before its expiry a cached token is refreshed
+const tokenRefreshMargin = time.Minute
+
 type oAuth2 struct {
-	token *oauth2.Token
+	source                   oauth2.TokenSource
+	requireTransportSecurity bool
+}
+
+// OAuth2Option configures an oauth2 PerRPCAuth
+type OAuth2Option func(*oAuth2)
+
+// WithRequireTransportSecurity returns an OAuth2Option which makes the calls fail instead of
+// sending the token when the client has no TransportAuth, e.g. : over plaintext tcp
+func WithRequireTransportSecurity() OAuth2Option {
+	return func(o *oAuth2) {
+		o.requireTransportSecurity = true
+	}
 }
 
 func (o *oAuth2) AuthType() string {
 	return "oauth2"
 }
 
-// NewOAuth2ByToken supports the generation of an oauth2 based on a string token
-func NewOAuth2ByToken(token string) *oAuth2 {
-	return &oAuth2{
-		token: &oauth2.Token{
-			AccessToken: token,
-			Expiry:      time.Now().Add(time.Hour),
-		},
-	}
+// NewOAuth2ByToken supports the generation of an oauth2 based on a string token, the token never expires
+func NewOAuth2ByToken(token string, opts ...OAuth2Option) *oAuth2 {
+	return newOAuth2(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}), opts)
+}
+
+// NewOAuth2 supports the generation of an oauth2 based on an oauth2 token, the calls fail once it expired
+func NewOAuth2(t *oauth2.Token, opts ...OAuth2Option) *oAuth2 {
+	return newOAuth2(oauth2.StaticTokenSource(t), opts)
 }
 
-// NewOAuth2 supports the generation of an oauth2 based on an oauth2 token
-func NewOAuth2(t *oauth2.Token) *oAuth2 {
-	return &oAuth2{
-		token: t,
+// NewOAuth2FromTokenSource supports the generation of an oauth2 based on a token source. The token
+// is fetched on the first call and cached, a new one is fetched by the first call within a minute of its expiry
+func NewOAuth2FromTokenSource(source oauth2.TokenSource, opts ...OAuth2Option) *oAuth2 {
+	return newOAuth2(oauth2.ReuseTokenSourceWithExpiry(nil, source, tokenRefreshMargin), opts)
+}
+
+// NewOAuth2ClientCredentials supports the generation of an oauth2 whose tokens are requested from the
+// token endpoint of the config with the client credentials flow
+func NewOAuth2ClientCredentials(config *clientcredentials.Config, opts ...OAuth2Option) *oAuth2 {
+	return NewOAuth2FromTokenSource(config.TokenSource(context.Background()), opts...)
+}
+
+func newOAuth2(source oauth2.TokenSource, opts []OAuth2Option) *oAuth2 {
+	o := &oAuth2{source: source}
+	for _, opt := range opts {
+		opt(o)
 	}
+	return o
+}
+
+// RequireTransportSecurity reports whether the token may only be sent over an authenticated transport
+func (o *oAuth2) RequireTransportSecurity() bool {
+	return o.requireTransportSecurity
 }
 
 // return the token format "Bear <access-token>"
 func (o *oAuth2) GetMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
 
-	if o.token == nil {
+	if o.source == nil {
 		return nil, codes.ClientCertFailError
 	}
 
+	token, err := o.source.Token()
+	if err != nil {
+		return nil, codes.NewFrameworkError(codes.ClientCertFail, "fetch oauth2 token: "+err.Error())
+	}
+	// the refresh margin is applied by the token source, a token within it is sent until it expired
+	if token.AccessToken == "" || (!token.Expiry.IsZero() && time.Now().After(token.Expiry)) {
+		return nil, codes.NewFrameworkError(codes.ClientCertFail, "oauth2 token missing or expired")
+	}
+
 	return map[string]string{
-		"authorization": o.token.Type() + " " + o.token.AccessToken,
+		"authorization": token.Type() + " " + token.AccessToken,
 	}, nil
 }
 
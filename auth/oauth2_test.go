@@ -0,0 +1,106 @@
+package auth_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/HuaTug/My-RPC/auth"
+	"github.com/HuaTug/My-RPC/client"
+	"github.com/HuaTug/My-RPC/codes"
+
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// tokenServer is a client credentials token endpoint issuing tokens valid for expiresIn seconds
+func tokenServer(t *testing.T, expiresIn int) (*clientcredentials.Config, *int32) {
+	var issued int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(&issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d}`, n, expiresIn)
+	}))
+	t.Cleanup(srv.Close)
+
+	return &clientcredentials.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		TokenURL:     srv.URL,
+	}, &issued
+}
+
+func authorization(t *testing.T, pra auth.PerRPCAuth) string {
+	md, err := pra.GetMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("GetMetadata() error = %v", err)
+	}
+	return md["authorization"]
+}
+
+func TestOAuth2ClientCredentialsFetchesLazilyAndCaches(t *testing.T) {
+	config, issued := tokenServer(t, 3600)
+
+	pra := auth.NewOAuth2ClientCredentials(config)
+	if n := atomic.LoadInt32(issued); n != 0 {
+		t.Fatalf("%d tokens fetched before the first call, want 0", n)
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := authorization(t, pra); got != "Bearer token-1" {
+			t.Fatalf("authorization = %q, want the cached Bearer token-1", got)
+		}
+	}
+	if n := atomic.LoadInt32(issued); n != 1 {
+		t.Errorf("%d tokens fetched, want 1", n)
+	}
+}
+
+func TestOAuth2ClientCredentialsRefreshesBeforeExpiry(t *testing.T) {
+	// the tokens expire within the refresh margin, every call fetches a new one
+	config, issued := tokenServer(t, 30)
+
+	pra := auth.NewOAuth2ClientCredentials(config)
+	for i := 1; i <= 3; i++ {
+		if got, want := authorization(t, pra), fmt.Sprintf("Bearer token-%d", i); got != want {
+			t.Fatalf("authorization = %q, want %q", got, want)
+		}
+	}
+	if n := atomic.LoadInt32(issued); n != 3 {
+		t.Errorf("%d tokens fetched, want 3", n)
+	}
+}
+
+func TestOAuth2ClientCredentialsFetchError(t *testing.T) {
+	config, _ := tokenServer(t, 3600)
+	config.ClientSecret = "wrong"
+
+	_, err := auth.NewOAuth2ClientCredentials(config).GetMetadata(context.Background())
+	if codes.CodeOf(err) != codes.Unauthenticated {
+		t.Fatalf("GetMetadata() error = %v, want code %v", err, codes.Unauthenticated)
+	}
+}
+
+func TestOAuth2RequireTransportSecurity(t *testing.T) {
+	config, issued := tokenServer(t, 3600)
+	pra := auth.NewOAuth2ClientCredentials(config, auth.WithRequireTransportSecurity())
+
+	// the call fails before the token is fetched or the request is sent
+	err := client.New().Call(context.Background(), "/test.Greeter/SayHello", &struct{}{}, &struct{}{},
+		client.WithTarget("127.0.0.1:1"),
+		client.WithNetwork("tcp"),
+		client.WithPerRPCAuth(pra),
+	)
+	if codes.CodeOf(err) != codes.FailedPrecondition {
+		t.Fatalf("Call() error = %v, want code %v", err, codes.FailedPrecondition)
+	}
+	if n := atomic.LoadInt32(issued); n != 0 {
+		t.Errorf("%d tokens fetched, want 0", n)
+	}
+}
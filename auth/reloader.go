@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/HuaTug/My-RPC/log"
+	"github.com/HuaTug/My-RPC/metrics"
+)
+
+var (
+	reloadsCounter      = metrics.GetCounter("gorpc_tls_reloads_total")
+	reloadErrorsCounter = metrics.GetCounter("gorpc_tls_reload_errors_total")
+)
+
+// defaultReloadInterval is how often the files are checked when no interval is set
+const defaultReloadInterval = time.Minute
+
+// CertReloader keeps a certificate and a CA bundle loaded from files, and reloads them when the
+// files change. The handshakes pick the current ones, established connections are not affected.
+// A failed reload keeps the previous certificate and CA bundle and is retried at the next check
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	opts     *ReloaderOptions
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	content []byte // content of the files last loaded
+	err     error  // error of the last reload, nil when it succeeded
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// ReloaderOptions defines the CertReloader parameters
+type ReloaderOptions struct {
+	Interval     time.Duration // how often the files are checked, default: 1 min
+	ErrorHandler func(error)   // called when a reload fails, e.g. : to alert before the certificate expires
+}
+
+type ReloaderOption func(*ReloaderOptions)
+
+// WithReloadInterval returns a ReloaderOption which sets how often the files are checked
+func WithReloadInterval(interval time.Duration) ReloaderOption {
+	return func(o *ReloaderOptions) {
+		o.Interval = interval
+	}
+}
+
+// WithReloadErrorHandler returns a ReloaderOption which sets the handler of the reload errors
+func WithReloadErrorHandler(handler func(error)) ReloaderOption {
+	return func(o *ReloaderOptions) {
+		o.ErrorHandler = handler
+	}
+}
+
+// NewCertReloader loads the certificate of certFile and keyFile and the CA bundle of caFile, then
+// checks them for changes until Close is called. certFile and keyFile, or caFile, may be empty
+func NewCertReloader(certFile string, keyFile string, caFile string, opts ...ReloaderOption) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		opts:     &ReloaderOptions{Interval: defaultReloadInterval},
+		done:     make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(r.opts)
+	}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	go r.watch()
+	return r, nil
+}
+
+func (r *CertReloader) watch() {
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				log.Errorf("tls reload of %s error, %v", r.files(), err)
+				if r.opts.ErrorHandler != nil {
+					r.opts.ErrorHandler(err)
+				}
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Reload loads the files again when their content changed
+func (r *CertReloader) Reload() error {
+	files, err := r.read()
+	content := bytes.Join(files, nil)
+	if err == nil && r.unchanged(content) {
+		return nil
+	}
+
+	var cert *tls.Certificate
+	var pool *x509.CertPool
+	var caNotAfter time.Time
+	if err == nil {
+		cert, pool, caNotAfter, err = r.parse(files[0], files[1], files[2])
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.err = err
+	if err != nil {
+		reloadErrorsCounter.Inc()
+		return err
+	}
+
+	r.cert, r.pool, r.content = cert, pool, content
+	reloadsCounter.Inc()
+	if cert != nil {
+		expiryGauge(r.certFile).Set(float64(cert.Leaf.NotAfter.Unix()))
+	}
+	if pool != nil {
+		expiryGauge(r.caFile).Set(float64(caNotAfter.Unix()))
+	}
+	return nil
+}
+
+// read returns the content of the certificate, key and CA files, nil for the ones not set
+func (r *CertReloader) read() ([][]byte, error) {
+	files := make([][]byte, 3)
+	for i, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		files[i] = data
+	}
+	return files, nil
+}
+
+func (r *CertReloader) unchanged(content []byte) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.content != nil && r.err == nil && bytes.Equal(r.content, content)
+}
+
+// parse builds the certificate and the CA bundle, and returns the earliest expiry of the CA bundle.
+// The certificate and the key may be written one after the other, a mismatch fails the reload
+// which is retried at the next check
+func (r *CertReloader) parse(certPEM []byte, keyPEM []byte, caPEM []byte) (*tls.Certificate, *x509.CertPool, time.Time, error) {
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, nil, time.Time{}, fmt.Errorf("%s: %v", r.certFile, err)
+		}
+		if c.Leaf, err = x509.ParseCertificate(c.Certificate[0]); err != nil {
+			return nil, nil, time.Time{}, err
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	var notAfter time.Time
+	if r.caFile != "" {
+		var err error
+		if pool, notAfter, err = parseCABundle(caPEM); err != nil {
+			return nil, nil, time.Time{}, fmt.Errorf("%s: %v", r.caFile, err)
+		}
+	}
+
+	return cert, pool, notAfter, nil
+}
+
+// parseCABundle returns the pool of the certificates of a PEM bundle, and the earliest expiry among them
+func parseCABundle(data []byte) (*x509.CertPool, time.Time, error) {
+	pool := x509.NewCertPool()
+	var notAfter time.Time
+
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		pool.AddCert(cert)
+		if notAfter.IsZero() || cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+
+	if notAfter.IsZero() {
+		return nil, time.Time{}, errors.New("no certificate found")
+	}
+	return pool, notAfter, nil
+}
+
+// expiryGauge returns the gauge holding the expiry time, in unix seconds, of the certificates of a file
+func expiryGauge(file string) *metrics.Gauge {
+	return metrics.GetGauge(fmt.Sprintf("gorpc_tls_cert_not_after_seconds{file=%q}", file))
+}
+
+func (r *CertReloader) files() string {
+	if r.certFile == "" {
+		return r.caFile
+	}
+	return r.certFile
+}
+
+// Certificate returns the current certificate, nil when the reloader has no certificate file
+func (r *CertReloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// CertPool returns the current CA bundle, nil when the reloader has no CA file
+func (r *CertReloader) CertPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// Err returns the error of the last reload, nil when it succeeded
+func (r *CertReloader) Err() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.err
+}
+
+// Close stops checking the files
+func (r *CertReloader) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+}
+
+// NewReloadingServerTLS returns a server TransportAuth presenting the current certificate of the
+// reloader. When the reloader has a CA bundle, the clients must present a certificate it issued
+func NewReloadingServerTLS(r *CertReloader) TransportAuth {
+	return &tlsAuth{
+		config:   &tls.Config{MinVersion: tls.VersionTLS12},
+		reloader: r,
+		server:   true,
+	}
+}
+
+// NewReloadingClientTLS returns a client TransportAuth verifying the server certificate against the
+// current CA bundle of the reloader, or the system roots when it has none, and presenting its
+// current certificate when it has one. serverName is as in NewClientTLSFromFile
+func NewReloadingClientTLS(r *CertReloader, serverName string) TransportAuth {
+	return &tlsAuth{
+		config:   &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName},
+		reloader: r,
+	}
+}
+
+// currentConfig returns the config of a handshake, with the current certificate and CA bundle of the reloader
+func (t *tlsAuth) currentConfig() *tls.Config {
+	if t.reloader == nil {
+		return t.config
+	}
+
+	config := t.config.Clone()
+	if cert := t.reloader.Certificate(); cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	if pool := t.reloader.CertPool(); pool != nil {
+		if t.server {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+			config.ClientCAs = pool
+		} else {
+			config.RootCAs = pool
+		}
+	}
+	return config
+}
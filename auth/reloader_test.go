@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate and its key, issued by parent or self-signed when parent is nil
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var serials int64
+
+func newTestCert(t *testing.T, name string, notAfter time.Time, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(atomic.AddInt64(&serials, 1)),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeKeyPair writes the certificate and the key files of c
+func writeKeyPair(t *testing.T, certFile, keyFile string, c *testCert) {
+	writeFile(t, certFile, c.pem)
+	writeFile(t, keyFile, c.keyPEM(t))
+}
+
+func TestCertReloaderPicksUpRewrittenFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	first := newTestCert(t, "first", time.Now().Add(time.Hour), nil)
+	writeKeyPair(t, certFile, keyFile, first)
+
+	r, err := NewCertReloader(certFile, keyFile, "", WithReloadInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	second := newTestCert(t, "second", time.Now().Add(2*time.Hour), nil)
+	writeKeyPair(t, certFile, keyFile, second)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload() = %v", err)
+	}
+
+	if got := r.Certificate().Leaf.Subject.CommonName; got != "second" {
+		t.Errorf("Certificate() = %s, want the rewritten certificate", got)
+	}
+	// the handshakes of the servers and the clients present the rewritten certificate
+	for _, ta := range []TransportAuth{NewReloadingServerTLS(r), NewReloadingClientTLS(r, "")} {
+		config := ta.(*tlsAuth).currentConfig()
+		if len(config.Certificates) != 1 || config.Certificates[0].Leaf.Subject.CommonName != "second" {
+			t.Errorf("handshake certificates = %v, want the rewritten certificate", config.Certificates)
+		}
+	}
+	if got, want := expiryGauge(certFile).Value(), float64(second.cert.NotAfter.Unix()); got != want {
+		t.Errorf("expiry gauge = %v, want %v", got, want)
+	}
+}
+
+func TestCertReloaderKeepsCertOnCorruptWrite(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	first := newTestCert(t, "first", time.Now().Add(time.Hour), nil)
+	writeKeyPair(t, certFile, keyFile, first)
+
+	failures := make(chan error, 16)
+	r, err := NewCertReloader(certFile, keyFile, "",
+		WithReloadInterval(10*time.Millisecond),
+		WithReloadErrorHandler(func(err error) {
+			select {
+			case failures <- err:
+			default:
+			}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	reloadErrors := reloadErrorsCounter.Value()
+
+	// the new certificate is written, the key is not yet
+	second := newTestCert(t, "second", time.Now().Add(2*time.Hour), nil)
+	writeFile(t, certFile, second.pem)
+
+	select {
+	case <-failures:
+	case <-time.After(time.Second):
+		t.Fatal("failed reload not reported")
+	}
+	if r.Err() == nil {
+		t.Error("Err() = nil, want the error of the failed reload")
+	}
+	if reloadErrorsCounter.Value() == reloadErrors {
+		t.Error("failed reload not counted")
+	}
+	if got := r.Certificate().Leaf.Subject.CommonName; got != "first" {
+		t.Errorf("Certificate() = %s, want the previous certificate kept", got)
+	}
+	if got, want := expiryGauge(certFile).Value(), float64(first.cert.NotAfter.Unix()); got != want {
+		t.Errorf("expiry gauge = %v, want the expiry of the previous certificate %v", got, want)
+	}
+
+	// the reload is retried once the key is written
+	writeFile(t, keyFile, second.keyPEM(t))
+	deadline := time.Now().Add(time.Second)
+	for r.Certificate().Leaf.Subject.CommonName != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate not reloaded once the files are consistent")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := r.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil once reloaded", err)
+	}
+
+	writeFile(t, certFile, []byte("not a certificate"))
+	if err := r.Reload(); err == nil {
+		t.Error("Reload() of a corrupt certificate = nil, want an error")
+	}
+	if got := r.Certificate().Leaf.Subject.CommonName; got != "second" {
+		t.Errorf("Certificate() = %s, want the previous certificate kept", got)
+	}
+}
+
+func TestCertReloaderCABundle(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+
+	oldCA := newTestCert(t, "old-ca", time.Now().Add(time.Hour), nil)
+	newCA := newTestCert(t, "new-ca", time.Now().Add(2*time.Hour), nil)
+	oldLeaf := newTestCert(t, "old-leaf", time.Now().Add(time.Hour), oldCA)
+	newLeaf := newTestCert(t, "new-leaf", time.Now().Add(time.Hour), newCA)
+
+	writeFile(t, caFile, oldCA.pem)
+	r, err := NewCertReloader("", "", caFile, WithReloadInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	verify := func(leaf *testCert) error {
+		_, err := leaf.cert.Verify(x509.VerifyOptions{Roots: r.CertPool()})
+		return err
+	}
+	if err := verify(oldLeaf); err != nil {
+		t.Errorf("certificate of the old CA rejected: %v", err)
+	}
+	if err := verify(newLeaf); err == nil {
+		t.Error("certificate of the new CA accepted before the reload")
+	}
+
+	writeFile(t, caFile, newCA.pem)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload() = %v", err)
+	}
+	if err := verify(newLeaf); err != nil {
+		t.Errorf("certificate of the new CA rejected after the reload: %v", err)
+	}
+	if err := verify(oldLeaf); err == nil {
+		t.Error("certificate of the old CA accepted after the reload")
+	}
+	if pool := NewReloadingClientTLS(r, "").(*tlsAuth).currentConfig().RootCAs; !pool.Equal(r.CertPool()) {
+		t.Error("client handshake does not verify against the reloaded CA bundle")
+	}
+
+	// the gauge holds the earliest expiry of the bundle
+	writeFile(t, caFile, append(append([]byte(nil), newCA.pem...), oldCA.pem...))
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload() = %v", err)
+	}
+	if got, want := expiryGauge(caFile).Value(), float64(oldCA.cert.NotAfter.Unix()); got != want {
+		t.Errorf("expiry gauge = %v, want the earliest expiry %v", got, want)
+	}
+}
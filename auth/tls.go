@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+
+	"github.com/HuaTug/My-RPC/codes"
+)
+
+// TLSInfo is the AuthInfo of a connection secured by TLS
+type TLSInfo struct {
+	State tls.ConnectionState
+}
+
+func (t TLSInfo) AuthType() string {
+	return "tls"
+}
+
+type tlsAuth struct {
+	config   *tls.Config
+	reloader *CertReloader // provides the certificate and the CA bundle of the handshakes when set
+	server   bool          // whether the CA bundle of the reloader verifies the clients
+}
+
+// NewTLS returns a TransportAuth securing the connections with TLS, the config is cloned for each handshake
+func NewTLS(config *tls.Config) TransportAuth {
+	c := config.Clone()
+	if c == nil {
+		c = &tls.Config{}
+	}
+	if c.MinVersion == 0 {
+		c.MinVersion = tls.VersionTLS12
+	}
+	return &tlsAuth{config: c}
+}
+
+// NewServerTLSFromFile returns a server TransportAuth presenting the certificate of certFile and keyFile
+func NewServerTLSFromFile(certFile string, keyFile string) (TransportAuth, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}}), nil
+}
+
+// NewClientTLSFromFile returns a client TransportAuth verifying the server certificate against the
+// CA bundle of caFile, or the system roots when caFile is empty. serverName overrides the name
+// checked in the certificate, which is the host of the target by default
+func NewClientTLSFromFile(caFile string, serverName string) (TransportAuth, error) {
+	config := &tls.Config{ServerName: serverName}
+
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	return NewTLS(config), nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificate found in " + caFile)
+	}
+	return pool, nil
+}
+
+// ClientHandshake runs the TLS handshake of a dialed connection, authority is the target address
+// whose host is sent as SNI and verified when the config has no ServerName
+func (t *tlsAuth) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, AuthInfo, error) {
+	config := t.currentConfig().Clone()
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(authority)
+		if err != nil {
+			host = authority
+		}
+		config.ServerName = host
+	}
+
+	conn := tls.Client(rawConn, config)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, nil, handshakeError(ctx, err)
+	}
+
+	return conn, TLSInfo{State: conn.ConnectionState()}, nil
+}
+
+// ServerHandshake runs the TLS handshake of an accepted connection, the caller bounds it with a deadline
+func (t *tlsAuth) ServerHandshake(rawConn net.Conn) (net.Conn, AuthInfo, error) {
+	conn := tls.Server(rawConn, t.currentConfig())
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, nil, handshakeError(context.Background(), err)
+	}
+
+	return conn, TLSInfo{State: conn.ConnectionState()}, nil
+}
+
+func handshakeError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return err
+	}
+	return codes.NewFrameworkError(codes.Unavailable, "tls handshake failed: "+err.Error())
+}
+
+type authInfoKey struct{}
+
+// NewContextWithAuthInfo returns a context carrying the AuthInfo of the connection of a request
+func NewContextWithAuthInfo(ctx context.Context, info AuthInfo) context.Context {
+	return context.WithValue(ctx, authInfoKey{}, info)
+}
+
+// AuthInfoFromContext returns the AuthInfo of the connection a request was received on, ok is false
+// when the connection was not authenticated
+func AuthInfoFromContext(ctx context.Context) (AuthInfo, bool) {
+	info, ok := ctx.Value(authInfoKey{}).(AuthInfo)
+	return info, ok
+}
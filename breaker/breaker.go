@@ -0,0 +1,189 @@
+// Package breaker implements client side circuit breakers, keyed by service name and node address
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codes"
+)
+
+// State is the state of a circuit breaker
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker is the circuit breaker of a single service node
+type Breaker struct {
+	opts *Options
+
+	mu          sync.Mutex
+	state       State
+	windowStart time.Time // start of the current statistics window
+	requests    int       // requests in the current window
+	failures    int       // failures in the current window
+	consecutive int       // consecutive failures
+	openedAt    time.Time // time the breaker was opened
+	probes      int       // probes in flight in half-open state
+	successes   int       // successful probes in half-open state
+}
+
+func newBreaker(opts *Options) *Breaker {
+	return &Breaker{
+		opts:        opts,
+		windowStart: time.Now(),
+	}
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh(time.Now())
+	return b.state
+}
+
+// Ready reports whether the node could receive a request, without reserving a probe
+func (b *Breaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh(time.Now())
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		return b.probes < b.opts.halfOpenRequests
+	default:
+		return true
+	}
+}
+
+// Allow reports whether a request may be sent to the node. In half-open state it reserves one of
+// the probes, so every allowed request must be followed by a Report
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh(time.Now())
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		if b.probes >= b.opts.halfOpenRequests {
+			return false
+		}
+		b.probes++
+		return true
+	default:
+		return true
+	}
+}
+
+// Report records the outcome of a request allowed by Allow
+func (b *Breaker) Report(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.refresh(now)
+
+	// a call given up by the caller tells nothing about the node, a probe releases its slot only
+	if codes.CodeOf(err) == codes.Canceled {
+		if b.state == StateHalfOpen && b.probes > 0 {
+			b.probes--
+		}
+		return
+	}
+
+	failed := isFailure(err)
+
+	switch b.state {
+	case StateHalfOpen:
+		if b.probes > 0 {
+			b.probes--
+		}
+		if failed {
+			b.open(now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.opts.halfOpenRequests {
+			b.close(now)
+		}
+
+	case StateClosed:
+		b.requests++
+		if !failed {
+			b.consecutive = 0
+			return
+		}
+		b.failures++
+		b.consecutive++
+
+		if b.opts.consecutiveFailures > 0 && b.consecutive >= b.opts.consecutiveFailures {
+			b.open(now)
+			return
+		}
+		if b.requests >= b.opts.minRequests &&
+			float64(b.failures)/float64(b.requests) >= b.opts.failureRatio {
+			b.open(now)
+		}
+	}
+}
+
+// refresh moves an open breaker to half-open after the open timeout and resets the
+// statistics window of a closed breaker
+func (b *Breaker) refresh(now time.Time) {
+	switch b.state {
+	case StateOpen:
+		if now.Sub(b.openedAt) >= b.opts.openTimeout {
+			b.state = StateHalfOpen
+			b.probes = 0
+			b.successes = 0
+		}
+	case StateClosed:
+		if now.Sub(b.windowStart) >= b.opts.window {
+			b.windowStart = now
+			b.requests = 0
+			b.failures = 0
+		}
+	}
+}
+
+func (b *Breaker) open(now time.Time) {
+	b.state = StateOpen
+	b.openedAt = now
+}
+
+func (b *Breaker) close(now time.Time) {
+	b.state = StateClosed
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+	b.consecutive = 0
+}
+
+// isFailure decides whether an error counts against the node. Requests given up by the caller and
+// errors answered for the request, e.g. : InvalidArgument or NotFound, do not
+func isFailure(err error) bool {
+	return codes.IsNodeFailure(err)
+}
@@ -0,0 +1,64 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codes"
+)
+
+func TestBreakerCountsNodeFailuresOnly(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		state State
+	}{
+		{"unavailable response", codes.New(codes.Unavailable, "overloaded"), StateOpen},
+		{"internal response", codes.New(codes.Internal, "panic"), StateOpen},
+		{"transport error", errors.New("invalid frame"), StateOpen},
+		{"request error", codes.New(codes.InvalidArgument, "bad name"), StateClosed},
+		{"business error", codes.New(codes.NotFound, "no such user"), StateClosed},
+		{"cancelled call", context.Canceled, StateClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGroup(WithConsecutiveFailures(3))
+			for i := 0; i < 3; i++ {
+				if !g.Allow("node") {
+					t.Fatalf("request %d not allowed", i)
+				}
+				g.Report("node", tt.err)
+			}
+			if got := g.Breaker("node").State(); got != tt.state {
+				t.Errorf("state = %v, want %v", got, tt.state)
+			}
+		})
+	}
+}
+
+func TestBreakerCancelledProbe(t *testing.T) {
+	g := NewGroup(WithConsecutiveFailures(1), WithOpenTimeout(10*time.Millisecond))
+	g.Allow("node")
+	g.Report("node", errors.New("connection reset"))
+
+	time.Sleep(20 * time.Millisecond)
+	if !g.Allow("node") {
+		t.Fatal("half-open breaker refused the probe")
+	}
+	g.Report("node", context.Canceled)
+
+	if got := g.Breaker("node").State(); got != StateHalfOpen {
+		t.Fatalf("state after a cancelled probe = %v, want %v", got, StateHalfOpen)
+	}
+	// the slot of the cancelled probe is free again
+	if !g.Allow("node") {
+		t.Fatal("half-open breaker refused a new probe")
+	}
+	g.Report("node", nil)
+	if got := g.Breaker("node").State(); got != StateClosed {
+		t.Errorf("state after a successful probe = %v, want %v", got, StateClosed)
+	}
+}
@@ -0,0 +1,72 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// Group holds the breakers of all nodes of one service, keyed by node address
+type Group struct {
+	opts     *Options
+	breakers *sync.Map
+}
+
+// NewGroup creates a Group, the breakers of its nodes share the given options
+func NewGroup(opt ...Option) *Group {
+	// default options
+	opts := &Options{
+		failureRatio:        0.5,
+		minRequests:         20,
+		consecutiveFailures: 5,
+		window:              10 * time.Second,
+		openTimeout:         5 * time.Second,
+		halfOpenRequests:    1,
+	}
+	for _, o := range opt {
+		o(opts)
+	}
+
+	return &Group{
+		opts:     opts,
+		breakers: new(sync.Map),
+	}
+}
+
+// Breaker returns the breaker of a node, creating it when needed
+func (g *Group) Breaker(addr string) *Breaker {
+	if b, ok := g.breakers.Load(addr); ok {
+		return b.(*Breaker)
+	}
+	b, _ := g.breakers.LoadOrStore(addr, newBreaker(g.opts))
+	return b.(*Breaker)
+}
+
+// Ready reports whether the node could receive a request
+func (g *Group) Ready(addr string) bool {
+	return g.Breaker(addr).Ready()
+}
+
+// Allow reports whether a request may be sent to the node
+func (g *Group) Allow(addr string) bool {
+	return g.Breaker(addr).Allow()
+}
+
+// Report records the outcome of a request sent to the node
+func (g *Group) Report(addr string, err error) {
+	g.Breaker(addr).Report(err)
+}
+
+var groupMap = new(sync.Map)
+
+// Register enables circuit breaking for a service
+func Register(serviceName string, group *Group) {
+	groupMap.Store(serviceName, group)
+}
+
+// Get returns the Group of a service, or nil when circuit breaking is not enabled for it
+func Get(serviceName string) *Group {
+	if g, ok := groupMap.Load(serviceName); ok {
+		return g.(*Group)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package breaker
+
+import "time"
+
+// Options defines the circuit breaker parameters
+type Options struct {
+	failureRatio        float64       // open when the failure ratio in a window reaches it
+	minRequests         int           // minimum requests in a window before failureRatio is considered
+	consecutiveFailures int           // open after this many failures in a row
+	window              time.Duration // statistics window of the closed state
+	openTimeout         time.Duration // time spent open before probing the node again
+	halfOpenRequests    int           // probes allowed in half-open state, all must succeed to close
+}
+
+type Option func(*Options)
+
+func WithFailureRatio(ratio float64) Option {
+	return func(o *Options) {
+		o.failureRatio = ratio
+	}
+}
+
+func WithMinRequests(minRequests int) Option {
+	return func(o *Options) {
+		o.minRequests = minRequests
+	}
+}
+
+func WithConsecutiveFailures(failures int) Option {
+	return func(o *Options) {
+		o.consecutiveFailures = failures
+	}
+}
+
+func WithWindow(window time.Duration) Option {
+	return func(o *Options) {
+		o.window = window
+	}
+}
+
+func WithOpenTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.openTimeout = timeout
+	}
+}
+
+func WithHalfOpenRequests(requests int) Option {
+	return func(o *Options) {
+		o.halfOpenRequests = requests
+	}
+}
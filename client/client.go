@@ -3,8 +3,10 @@ package client
 import (
 	"context"
 	"fmt"
-	"log"
+	"strconv"
+	"time"
 
+	"github.com/HuaTug/My-RPC/auth"
 	"github.com/HuaTug/My-RPC/codec"
 	"github.com/HuaTug/My-RPC/codes"
 	connpool "github.com/HuaTug/My-RPC/pool"
@@ -59,13 +61,15 @@ func (c *defaultClient) Call(ctx context.Context, servicePath string, req interf
 
 func (c *defaultClient) Invoke(ctx context.Context, req, rsp interface{}, path string, opts ...Option) error {
 
+	// the options apply to this call only, the client is shared by the concurrent calls
+	callOpts := c.opts.clone()
 	for _, o := range opts {
-		o(c.opts)
+		o(callOpts)
 	}
 
-	if c.opts.timeout > 0 {
+	if callOpts.timeout > 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, c.opts.timeout)
+		ctx, cancel = context.WithTimeout(ctx, callOpts.timeout)
 		defer cancel()
 	}
 
@@ -77,8 +81,8 @@ func (c *defaultClient) Invoke(ctx context.Context, req, rsp interface{}, path s
 		return err
 	}
 
-	c.opts.serviceName = serviceName
-	c.opts.method = method
+	callOpts.serviceName = serviceName
+	callOpts.method = method
 
 	// TODO : delete or not
 	// 这是使用stream流进行操作，基于Http协议
@@ -86,23 +90,47 @@ func (c *defaultClient) Invoke(ctx context.Context, req, rsp interface{}, path s
 	clientStream.WithMethod(method)
 
 	// execute the interceptor first
-	//log.Println("invoke interceptor...", c.opts.interceptors)
-	return interceptor.ClientIntercept(newCtx, req, rsp, c.opts.interceptors, c.invoke)
+	//log.Println("invoke interceptor...", callOpts.interceptors)
+	err = interceptor.ClientIntercept(newCtx, req, rsp, callOpts.interceptors, func(ctx context.Context, req, rsp interface{}) error {
+		return c.invoke(ctx, req, rsp, callOpts)
+	})
+
+	// the interceptors (e.g. hedging) leave the metadata of the response they returned in the stream
+	header, trailer := metadata.SplitResponse(clientStream.RspMetadata)
+	if callOpts.responseMetadata != nil {
+		*callOpts.responseMetadata = metadata.MergeResponse(clientStream.RspMetadata)
+	}
+	if callOpts.responseHeader != nil {
+		*callOpts.responseHeader = header
+	}
+	if callOpts.responseTrailer != nil {
+		*callOpts.responseTrailer = trailer
+	}
+
+	return err
 }
 
-func (c *defaultClient) invoke(ctx context.Context, req, rsp interface{}) error {
+func (c *defaultClient) invoke(ctx context.Context, req, rsp interface{}, opts *Options) error {
+	// the deadline may have already passed in the interceptors
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	//此时的serialization是msgpack（序列化协议由Client客户端传入的决定）
-	serialization := codec.GetSerialization(c.opts.serializationType)
+	serialization := codec.GetSerialization(opts.serializationType)
 	payload, err := serialization.Marshal(req)
 	if err != nil {
 		return codes.NewFrameworkError(codes.ClientMsgErrorCode, "request marshal failed ...")
 	}
 	//log.Println("request payload : ", payload) 用于编码使用
 	//进行编码到网络层，然后网络在传输到目标主机
-	clientCodec := codec.GetCodec(c.opts.protocol)
+	clientCodec := codec.GetCodec(opts.protocol)
 
 	// assemble header
-	request := addReqHeader(ctx, c, payload)
+	request, err := addReqHeader(ctx, opts, payload)
+	if err != nil {
+		return err
+	}
 	reqbuf, err := proto.Marshal(request)
 	if err != nil {
 		return err
@@ -114,36 +142,57 @@ func (c *defaultClient) invoke(ctx context.Context, req, rsp interface{}) error
 	}
 	// 先进行了序列化，然后再对其进行编码操作（序列化是将数据转化为二进制流，而编码则是将二进制流转化为特定的传输格式）
 
+	// the transport checks the response code to report the errors of the server to the circuit breaker,
+	// the response it decoded is kept
+	var response *protocol.Response
+	decodeResponse := func(frame []byte) error {
+		rspbuf, err := clientCodec.Decode(frame)
+		if err != nil {
+			return err
+		}
+		rsp := &protocol.Response{}
+		if err := proto.Unmarshal(rspbuf, rsp); err != nil {
+			return err
+		}
+		response = rsp
+		if rsp.RetCode != uint32(codes.OK) {
+			return codes.FromResponse(rsp.RetCode, rsp.RetMsg, metadata.MergeResponse(rsp.Metadata))
+		}
+		return nil
+	}
+
 	// 接着便是进行传输层，即Client端的传输
-	clientTransport := c.NewClientTransport()
+	clientTransport := transport.GetClientTransport(opts.protocol)
 	clientTransportOpts := []transport.ClientTransportOption{
-		transport.WithServiceName(c.opts.serviceName),
-		transport.WithClientTarget(c.opts.target),
-		transport.WithClientNetwork(c.opts.network),
+		transport.WithServiceName(opts.serviceName),
+		transport.WithClientTarget(opts.target),
+		transport.WithClientNetwork(opts.network),
 		transport.WithClientPool(connpool.GetPool("default")),
-		transport.WithSelector(selector.GetSelector(c.opts.selectorName)),
-		transport.WithTimeout(c.opts.timeout),
+		transport.WithSelector(selector.GetSelector(opts.selectorName)),
+		transport.WithSelectOptions(opts.selectOpts...),
+		transport.WithTimeout(opts.timeout),
+		transport.WithClientTransportAuth(opts.transportAuth),
+		transport.WithResponseCheck(decodeResponse),
 	}
 
 	// clientTransport实现了Send方法
 	frame, err := clientTransport.Send(ctx, reqbody, clientTransportOpts...)
 	if err != nil {
-		return err
+		// context and network errors get their canonical code
+		return codes.FromError(err)
 	}
 
-	rspbuf, err := clientCodec.Decode(frame)
-	if err != nil {
-		return err
+	// parse protocol header, unless the transport already did
+	if response == nil {
+		if err = decodeResponse(frame); response == nil {
+			return err
+		}
 	}
 
-	// parse protocol header
-	response := &protocol.Response{}
-	if err = proto.Unmarshal(rspbuf, response); err != nil {
-		return err
-	}
+	stream.GetClientStream(ctx).RspMetadata = response.Metadata
 
-	if response.RetCode != 0 {
-		return codes.New(response.RetCode, response.RetMsg)
+	if response.RetCode != uint32(codes.OK) {
+		return codes.FromResponse(response.RetCode, response.RetMsg, metadata.MergeResponse(response.Metadata))
 	}
 
 	// return serialization.Unmarshal(response.Payload, rsp)
@@ -160,27 +209,73 @@ func (c *defaultClient) NewClientTransport() transport.ClientTransport {
 	return transport.GetClientTransport(c.opts.protocol)
 }
 
-func addReqHeader(ctx context.Context, client *defaultClient, payload []byte) *protocol.Request {
+func addReqHeader(ctx context.Context, opts *Options, payload []byte) (*protocol.Request, error) {
 	clientStream := stream.GetClientStream(ctx)
 	//log.Println("clientStream : ", clientStream)
 	servicePath := fmt.Sprintf("/%s/%s", clientStream.ServiceName, clientStream.Method)
 	//这个md是用来设置client的上下文ctx，用于传输数据（例如认证信息）
-	md := metadata.ClientMetadata(ctx)
+	// copy the metadata so that concurrent attempts of the same call (e.g. hedged requests)
+	// never write into the map shared through the context
+	md := make(map[string][]byte)
+	for k, v := range metadata.ClientMetadata(ctx) {
+		md[k] = v
+	}
+
+	if outgoing, ok := metadata.FromOutgoingContext(ctx); ok {
+		raw, err := metadata.Encode(outgoing)
+		if err != nil {
+			return nil, codes.NewFrameworkError(codes.ClientMsgErrorCode, err.Error())
+		}
+		for k, v := range raw {
+			md[k] = v
+		}
+	}
 
 	// fill the authentication information
-	for _, pra := range client.opts.perRPCAuth {
-		authMd, _ := pra.GetMetadata(ctx)
-		log.Println(authMd)
+	for _, pra := range opts.perRPCAuth {
+		if r, ok := pra.(auth.TransportSecurityRequirer); ok && r.RequireTransportSecurity() && opts.transportAuth == nil {
+			return nil, codes.NewFrameworkError(codes.FailedPrecondition, "credentials require transport security, no TransportAuth set")
+		}
+		var authMd map[string]string
+		var err error
+		if pa, ok := pra.(auth.PayloadAuth); ok {
+			authMd, err = pa.GetPayloadMetadata(ctx, servicePath, payload)
+		} else {
+			authMd, err = pra.GetMetadata(ctx, servicePath)
+		}
+		if err != nil {
+			if _, ok := err.(*codes.Error); !ok {
+				err = codes.NewFrameworkError(codes.ClientCertFail, err.Error())
+			}
+			return nil, err
+		}
 		for k, v := range authMd {
 			md[k] = []byte(v)
 		}
 	}
 
+	// propagate the time left before the deadline, so that the server stops when the caller gives up
+	if deadline, ok := ctx.Deadline(); ok {
+		ms := time.Until(deadline).Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		md[metadata.TimeoutKey] = []byte(strconv.FormatInt(ms, 10))
+	}
+
+	maxSize := opts.maxMetadataSize
+	if maxSize == 0 {
+		maxSize = metadata.DefaultMaxSize
+	}
+	if metadata.Size(md) > maxSize {
+		return nil, codes.NewFrameworkError(codes.ClientMsgErrorCode, metadata.ErrTooLarge.Error())
+	}
+
 	request := &protocol.Request{
 		ServicePath: servicePath,
 		Payload:     payload,
 		Metadata:    md,
 	}
 
-	return request
+	return request, nil
 }
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/HuaTug/My-RPC/codes"
+	"github.com/HuaTug/My-RPC/metadata"
+	"github.com/HuaTug/My-RPC/stream"
+)
+
+// callContext returns the context of a call to /test.Greeter/SayHello carrying a value of size bytes
+func callContext(size int) context.Context {
+	ctx, cs := stream.NewClientStream(context.Background())
+	cs.WithServiceName("test.Greeter")
+	cs.WithMethod("SayHello")
+	return metadata.AppendToOutgoingContext(ctx, "k", strings.Repeat("v", size))
+}
+
+func TestRequestMetadataSizeLimit(t *testing.T) {
+	// the key counts towards the limit
+	if _, err := addReqHeader(callContext(metadata.DefaultMaxSize-1), &Options{}, nil); err != nil {
+		t.Errorf("metadata of the maximum size rejected: %v", err)
+	}
+
+	_, err := addReqHeader(callContext(metadata.DefaultMaxSize), &Options{}, nil)
+	if codes.CodeOf(err) != codes.InvalidArgument {
+		t.Errorf("metadata over the default limit: error = %v, want code %v", err, codes.InvalidArgument)
+	}
+
+	opts := &Options{}
+	WithMaxMetadataSize(2 * metadata.DefaultMaxSize)(opts)
+	if _, err := addReqHeader(callContext(metadata.DefaultMaxSize), opts, nil); err != nil {
+		t.Errorf("metadata within the configured limit rejected: %v", err)
+	}
+}
+
+func TestReservedOutgoingMetadataRejected(t *testing.T) {
+	ctx, cs := stream.NewClientStream(context.Background())
+	cs.WithServiceName("test.Greeter")
+	cs.WithMethod("SayHello")
+	ctx = metadata.AppendToOutgoingContext(ctx, "Gorpc-Timeout", "1")
+
+	if _, err := addReqHeader(ctx, &Options{}, nil); codes.CodeOf(err) != codes.InvalidArgument {
+		t.Errorf("reserved outgoing key: error = %v, want code %v", err, codes.InvalidArgument)
+	}
+}
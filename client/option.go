@@ -5,6 +5,7 @@ import (
 
 	"github.com/HuaTug/My-RPC/auth"
 	"github.com/HuaTug/My-RPC/interceptor"
+	"github.com/HuaTug/My-RPC/selector"
 	"github.com/HuaTug/My-RPC/transport"
 )
 
@@ -12,7 +13,7 @@ import (
 type Options struct {
 	serviceName       string        // service name
 	method            string        // method name
-	target            string        // format e.g.:  ip:port 127.0.0.1:8000
+	target            string        // format e.g.:  ip:port 127.0.0.1:8000, unix:///run/app.sock
 	timeout           time.Duration // timeout
 	network           string        // network type, e.g.:  tcp、udp
 	protocol          string        // protocol type , e.g. : proto、json
@@ -22,10 +23,23 @@ type Options struct {
 	selectorName      string            // service discovery name, e.g. : consul、zookeeper、etcd
 	perRPCAuth        []auth.PerRPCAuth // authentication information required for each RPC call
 	transportAuth     auth.TransportAuth
+	responseMetadata  *map[string][]byte // receives the headers merged with the trailers of the response
+	responseHeader    *map[string][]byte // receives the headers of the response
+	responseTrailer   *map[string][]byte // receives the trailers of the response
+	maxMetadataSize   int                // request metadata size limit, default: metadata.DefaultMaxSize
+	selectOpts        []selector.Option  // options of the selector, e.g. : a filter of the nodes
 }
 
 type Option func(*Options)
 
+// clone returns a copy of the options, the options of a call are applied to the copy
+func (o *Options) clone() *Options {
+	c := *o
+	c.interceptors = append([]interceptor.ClientInterceptor(nil), o.interceptors...)
+	c.perRPCAuth = append([]auth.PerRPCAuth(nil), o.perRPCAuth...)
+	return &c
+}
+
 func WithServiceName(serviceName string) Option {
 	return func(o *Options) {
 		o.serviceName = serviceName
@@ -91,3 +105,39 @@ func WithTransportAuth(transportAuth auth.TransportAuth) Option {
 		o.transportAuth = transportAuth
 	}
 }
+
+// WithResponseMetadata receives the metadata sent back by the server : the headers merged with
+// the trailers, a trailer overriding a header of the same key
+func WithResponseMetadata(md *map[string][]byte) Option {
+	return func(o *Options) {
+		o.responseMetadata = md
+	}
+}
+
+// WithResponseHeader receives the headers sent back by the server
+func WithResponseHeader(md *map[string][]byte) Option {
+	return func(o *Options) {
+		o.responseHeader = md
+	}
+}
+
+// WithResponseTrailer receives the trailers sent back by the server
+func WithResponseTrailer(md *map[string][]byte) Option {
+	return func(o *Options) {
+		o.responseTrailer = md
+	}
+}
+
+// WithMaxMetadataSize limits the size of the request metadata, keys and values included
+func WithMaxMetadataSize(size int) Option {
+	return func(o *Options) {
+		o.maxMetadataSize = size
+	}
+}
+
+// WithSelectOptions sets the options given to the selector, e.g. : selector.WithFilter to call the nodes of a zone
+func WithSelectOptions(opts ...selector.Option) Option {
+	return func(o *Options) {
+		o.selectOpts = opts
+	}
+}
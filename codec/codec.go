@@ -19,6 +19,14 @@ const FrameHeadLen = 15
 const Magic = 0x11
 const Version = 0
 
+// msg types
+const (
+	MsgTypeRequest   = 0x0 // general request or response
+	MsgTypeHeartbeat = 0x1 // heartbeat
+	MsgTypeCancel    = 0x2 // the caller gave up the request of the frame stream ID
+	MsgTypeGoAway    = 0x3 // the server does not handle requests from the connection anymore
+)
+
 // FrameHeader describes the header structure of a data frame
 type FrameHeader struct {
 	Magic        uint8  // magic
@@ -63,18 +71,63 @@ func RegisterCodec(name string, codec Codec) {
 
 func (c *defaultCodec) Encode(data []byte) ([]byte, error) {
 
-	totalLen := FrameHeadLen + len(data)
-	buffer := bytes.NewBuffer(make([]byte, 0, totalLen))
-
-	frame := FrameHeader{
+	frame := &FrameHeader{
 		Magic:        Magic,
 		Version:      Version,
-		MsgType:      0x0,
+		MsgType:      MsgTypeRequest,
 		ReqType:      0x0,
 		CompressType: 0x0,
-		Length:       uint32(len(data)),
 	}
 
+	return EncodeFrame(frame, data)
+}
+
+// NewCancelFrame builds the frame telling the server to cancel the request of a stream
+func NewCancelFrame(streamID uint16) ([]byte, error) {
+	frame := &FrameHeader{
+		Magic:    Magic,
+		Version:  Version,
+		MsgType:  MsgTypeCancel,
+		StreamID: streamID,
+	}
+
+	return EncodeFrame(frame, nil)
+}
+
+// NewGoAwayFrame builds the frame telling the client to move its requests to a new connection
+func NewGoAwayFrame() ([]byte, error) {
+	frame := &FrameHeader{
+		Magic:   Magic,
+		Version: Version,
+		MsgType: MsgTypeGoAway,
+	}
+
+	return EncodeFrame(frame, nil)
+}
+
+// GetMsgType returns the msg type of an encoded frame
+func GetMsgType(frame []byte) uint8 {
+	return frame[2]
+}
+
+// GetStreamID returns the stream ID of an encoded frame
+func GetStreamID(frame []byte) uint16 {
+	return binary.BigEndian.Uint16(frame[5:7])
+}
+
+// SetStreamID sets the stream ID of an encoded frame
+func SetStreamID(frame []byte, streamID uint16) {
+	binary.BigEndian.PutUint16(frame[5:7], streamID)
+}
+
+// EncodeFrame writes the frame header followed by data, the header Length is set to the length of data
+func EncodeFrame(frame *FrameHeader, data []byte) ([]byte, error) {
+
+	totalLen := FrameHeadLen + len(data)
+	buffer := bytes.NewBuffer(make([]byte, 0, totalLen))
+
+	frame.Length = uint32(len(data))
+
 	if err := binary.Write(buffer, binary.BigEndian, frame.Magic); err != nil {
 		return nil, err
 	}
@@ -1,14 +1,83 @@
 package codes
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Code is a canonical status code, the code set is compatible with gRPC
+type Code uint32
+
+const (
+	OK                 Code = 0  // not an error
+	Canceled           Code = 1  // the call was cancelled by the caller
+	Unknown            Code = 2  // unknown error, e.g. : an error without a code returned by a handler
+	InvalidArgument    Code = 3  // the request is invalid, whatever the state of the system
+	DeadlineExceeded   Code = 4  // the deadline expired before the call completed
+	NotFound           Code = 5  // a requested entity was not found
+	AlreadyExists      Code = 6  // the entity a caller attempted to create already exists
+	PermissionDenied   Code = 7  // the caller is identified but not allowed to do the call
+	ResourceExhausted  Code = 8  // a resource has been exhausted, e.g. : a rate limit
+	FailedPrecondition Code = 9  // the system is not in a state required for the call
+	Aborted            Code = 10 // the call was aborted, e.g. : a concurrency conflict
+	OutOfRange         Code = 11 // the call was attempted past the valid range
+	Unimplemented      Code = 12 // the call is not implemented or not supported
+	Internal           Code = 13 // an invariant of the system has been broken
+	Unavailable        Code = 14 // the service is currently unavailable, retrying may help
+	DataLoss           Code = 15 // unrecoverable data loss or corruption
+	Unauthenticated    Code = 16 // the caller has no valid authentication credentials
+)
+
+var codeNames = map[Code]string{
+	OK:                 "OK",
+	Canceled:           "Canceled",
+	Unknown:            "Unknown",
+	InvalidArgument:    "InvalidArgument",
+	DeadlineExceeded:   "DeadlineExceeded",
+	NotFound:           "NotFound",
+	AlreadyExists:      "AlreadyExists",
+	PermissionDenied:   "PermissionDenied",
+	ResourceExhausted:  "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition",
+	Aborted:            "Aborted",
+	OutOfRange:         "OutOfRange",
+	Unimplemented:      "Unimplemented",
+	Internal:           "Internal",
+	Unavailable:        "Unavailable",
+	DataLoss:           "DataLoss",
+	Unauthenticated:    "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return "Code(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
+
+// Deprecated: the former framework codes, kept as aliases of the canonical codes. FromResponse
+// decodes their former values
+const (
+	ServerInternalErrorCode      = Internal
+	ConfigErrorCode              = FailedPrecondition
+	NetworkNotSupportedErrorCode = Unimplemented
+	ClientMsgErrorCode           = InvalidArgument
+	ClientCertFail               = Unauthenticated
+)
+
+// Domain is the ErrorInfo domain of the reasons set by the framework
+const Domain = "gorpc"
 
+// reasons telling apart the framework errors sharing a code, see HasReason
 const (
-	OK                           = 0
-	ServerInternalErrorCode      = 100
-	ConfigErrorCode              = 101
-	NetworkNotSupportedErrorCode = 201
-	ClientMsgErrorCode           = 301
-	ClientCertFail               = 401
+	ReasonServerOverloaded  = "SERVER_OVERLOADED"  // the server sheds load
+	ReasonConnectionClosing = "CONNECTION_CLOSING" // the server closes the connection, the request was not handled
+	ReasonCircuitOpen       = "CIRCUIT_OPEN"       // the circuit breakers of all the nodes are open
+	ReasonNodeCircuitOpen   = "NODE_CIRCUIT_OPEN"  // the circuit breaker of the selected node is open
 )
 
 // errorcode type
@@ -19,17 +88,25 @@ const (
 
 // framework error
 var (
-	ServerInternalError      = NewFrameworkError(ServerInternalErrorCode, "server internal error")
-	ConfigError              = NewFrameworkError(ConfigErrorCode, "config error")
-	NetworkNotSupportedError = NewFrameworkError(NetworkNotSupportedErrorCode, "network type not supported")
-	ClientCertFailError      = NewFrameworkError(ClientCertFail, "client cert fail")
+	ServerInternalError      = NewFrameworkError(Internal, "server internal error")
+	ConfigError              = NewFrameworkError(FailedPrecondition, "config error")
+	ResourceExhaustedError   = NewFrameworkError(ResourceExhausted, "resource exhausted")
+	ServerOverloadError      = NewFrameworkError(Unavailable, "server overloaded").WithReason(ReasonServerOverloaded)
+	DeadlineExceededError    = NewFrameworkError(DeadlineExceeded, "deadline exceeded")
+	CanceledError            = NewFrameworkError(Canceled, "call canceled")
+	NetworkNotSupportedError = NewFrameworkError(Unimplemented, "network type not supported")
+	CircuitBreakerOpenError  = NewFrameworkError(Unavailable, "circuit breaker open for all nodes").WithReason(ReasonCircuitOpen)
+	ClientCertFailError      = NewFrameworkError(Unauthenticated, "client cert fail")
 )
 
 // Error defines all errors in the framework
 type Error struct {
-	Code    uint32
-	Type    int
-	Message string
+	Code     Code
+	Type     int
+	Message  string
+	Details  []Detail          // structured details, e.g. : retry info, field violations
+	Metadata map[string][]byte // passed to the caller in the response metadata, e.g. : retry hints
+	cause    error             // the error converted by FromError, if any
 }
 
 const (
@@ -46,8 +123,59 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("type : business, code : %d, msg : %s", e.Code, e.Message)
 }
 
+// Unwrap returns the error converted by FromError, if any
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether the error matches target : errors of the same code and message match,
+// a target without message matches any error of its code. Canceled and DeadlineExceeded
+// errors also match the corresponding context errors
+func (e *Error) Is(target error) bool {
+	if t, ok := target.(*Error); ok {
+		return e.Code == t.Code && (t.Message == "" || e.Message == t.Message)
+	}
+	switch target {
+	case context.Canceled:
+		return e.Code == Canceled
+	case context.DeadlineExceeded:
+		return e.Code == DeadlineExceeded
+	}
+	return false
+}
+
+func (e *Error) clone() *Error {
+	c := *e
+	return &c
+}
+
+// WithMetadata returns a copy of the error carrying an additional metadata key-value pair
+func (e *Error) WithMetadata(key string, value []byte) *Error {
+	md := make(map[string][]byte, len(e.Metadata)+1)
+	for k, v := range e.Metadata {
+		md[k] = v
+	}
+	md[key] = value
+
+	c := e.clone()
+	c.Metadata = md
+	return c
+}
+
+// WithDetails returns a copy of the error carrying additional details
+func (e *Error) WithDetails(details ...Detail) *Error {
+	c := e.clone()
+	c.Details = append(append([]Detail(nil), e.Details...), details...)
+	return c
+}
+
+// WithReason returns a copy of the error carrying an ErrorInfo detail of the framework domain
+func (e *Error) WithReason(reason string) *Error {
+	return e.WithDetails(&ErrorInfo{Reason: reason, Domain: Domain})
+}
+
 // new a framework type error
-func NewFrameworkError(code uint32, msg string) *Error {
+func NewFrameworkError(code Code, msg string) *Error {
 	return &Error{
 		Type:    FrameworkError,
 		Code:    code,
@@ -56,10 +184,100 @@ func NewFrameworkError(code uint32, msg string) *Error {
 }
 
 // new a business type error
-func New(code uint32, msg string) *Error {
+func New(code Code, msg string) *Error {
 	return &Error{
 		Type:    BusinuessError,
 		Code:    code,
 		Message: msg,
 	}
 }
+
+// Errorf creates a business type error with a formatted message
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	return New(code, fmt.Sprintf(format, a...))
+}
+
+// CodeOf returns the code of an error, OK for nil
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	return FromError(err).Code
+}
+
+// FromError converts any error into an *Error : errors already carrying a code are returned as is,
+// context and network errors get the matching code, others are Unknown. It returns nil for nil
+func FromError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	if ce := FromContextError(err); ce != nil {
+		return ce
+	}
+
+	if ne := FromNetworkError(err); ne != nil {
+		return ne
+	}
+
+	return &Error{
+		Type:    FrameworkError,
+		Code:    Unknown,
+		Message: err.Error(),
+		cause:   err,
+	}
+}
+
+// IsNodeFailure reports whether an error tells that the node serving the call failed, e.g. : it is
+// unreachable, overloaded or broken, rather than the request or the caller. Errors without a code
+// are failures of the transport, but the calls given up by the caller are not
+func IsNodeFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var e *Error
+	if !errors.As(err, &e) {
+		return true
+	}
+	switch e.Code {
+	case DeadlineExceeded, Internal, Unavailable, DataLoss:
+		return true
+	}
+	return false
+}
+
+// FromContextError converts context.Canceled and context.DeadlineExceeded into Canceled and
+// DeadlineExceeded errors, it returns nil for other errors
+func FromContextError(err error) *Error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return &Error{Type: FrameworkError, Code: Canceled, Message: err.Error(), cause: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Type: FrameworkError, Code: DeadlineExceeded, Message: err.Error(), cause: err}
+	}
+	return nil
+}
+
+// FromNetworkError converts network errors : timeouts become DeadlineExceeded, failures to reach
+// or talk to the peer become Unavailable. It returns nil for other errors
+func FromNetworkError(err error) *Error {
+	var ne net.Error
+	if errors.As(err, &ne) {
+		if ne.Timeout() {
+			return &Error{Type: FrameworkError, Code: DeadlineExceeded, Message: err.Error(), cause: err}
+		}
+		return &Error{Type: FrameworkError, Code: Unavailable, Message: err.Error(), cause: err}
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return &Error{Type: FrameworkError, Code: Unavailable, Message: err.Error(), cause: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package codes
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// DetailsKey is the response metadata key carrying the error details, json encoded
+const DetailsKey = "gorpc-status-details"
+
+// Detail is a structured error detail
+type Detail interface {
+	// DetailType returns the name identifying the detail type on the wire
+	DetailType() string
+}
+
+// RetryInfo tells the caller when it may retry
+type RetryInfo struct {
+	RetryDelay time.Duration `json:"retry_delay"`
+}
+
+func (*RetryInfo) DetailType() string { return "retry_info" }
+
+// FieldViolation describes a single invalid field of a request
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// BadRequest describes the violations of an invalid request
+type BadRequest struct {
+	FieldViolations []FieldViolation `json:"field_violations"`
+}
+
+func (*BadRequest) DetailType() string { return "bad_request" }
+
+// DebugInfo carries debugging information, e.g. : a stack trace
+type DebugInfo struct {
+	StackEntries []string `json:"stack_entries"`
+	Detail       string   `json:"detail"`
+}
+
+func (*DebugInfo) DetailType() string { return "debug_info" }
+
+// ErrorInfo describes the reason of an error with a machine readable identifier
+type ErrorInfo struct {
+	Reason   string            `json:"reason"`
+	Domain   string            `json:"domain"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+func (*ErrorInfo) DetailType() string { return "error_info" }
+
+// HasReason reports whether the error carries an ErrorInfo detail of the framework domain with the
+// reason, e.g. : to tell an overloaded server from a connection being closed, both Unavailable
+func HasReason(err error, reason string) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	for _, d := range e.Details {
+		if info, ok := d.(*ErrorInfo); ok && info.Domain == Domain && info.Reason == reason {
+			return true
+		}
+	}
+	return false
+}
+
+var detailTypes = map[string]func() Detail{
+	"retry_info":  func() Detail { return &RetryInfo{} },
+	"bad_request": func() Detail { return &BadRequest{} },
+	"debug_info":  func() Detail { return &DebugInfo{} },
+	"error_info":  func() Detail { return &ErrorInfo{} },
+}
+
+// RegisterDetail registers a custom detail type, so that it can be decoded by the caller
+func RegisterDetail(newDetail func() Detail) {
+	detailTypes[newDetail().DetailType()] = newDetail
+}
+
+type wireDetail struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// EncodeDetails encodes details into their wire format
+func EncodeDetails(details []Detail) ([]byte, error) {
+	wire := make([]wireDetail, 0, len(details))
+	for _, d := range details {
+		value, err := json.Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		wire = append(wire, wireDetail{Type: d.DetailType(), Value: value})
+	}
+	return json.Marshal(wire)
+}
+
+// DecodeDetails decodes details from their wire format, unknown detail types are skipped
+func DecodeDetails(data []byte) ([]Detail, error) {
+	var wire []wireDetail
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return nil, err
+	}
+
+	details := make([]Detail, 0, len(wire))
+	for _, w := range wire {
+		newDetail, ok := detailTypes[w.Type]
+		if !ok {
+			continue
+		}
+		d := newDetail()
+		if err := json.Unmarshal(w.Value, d); err != nil {
+			return nil, err
+		}
+		details = append(details, d)
+	}
+	return details, nil
+}
+
+// legacyCodes maps the codes sent by the servers built before the canonical codes
+var legacyCodes = map[uint32]Code{
+	100: Internal,           // ServerInternalErrorCode
+	101: FailedPrecondition, // ConfigErrorCode
+	201: Unimplemented,      // NetworkNotSupportedErrorCode
+	301: InvalidArgument,    // ClientMsgErrorCode
+	401: Unauthenticated,    // ClientCertFail
+}
+
+// FromResponse rebuilds the error sent by the server from the response header, the codes
+// of the servers built before the canonical codes are decoded as their canonical code
+func FromResponse(code uint32, msg string, md map[string][]byte) *Error {
+	c := Code(code)
+	if legacy, ok := legacyCodes[code]; ok {
+		c = legacy
+	}
+	e := New(c, msg)
+
+	if len(md) == 0 {
+		return e
+	}
+
+	e.Metadata = make(map[string][]byte, len(md))
+	for k, v := range md {
+		if k == DetailsKey {
+			if details, err := DecodeDetails(v); err == nil {
+				e.Details = details
+			}
+			continue
+		}
+		e.Metadata[k] = v
+	}
+
+	return e
+}
+
+// ToResponse returns the code, message and metadata sending an error to the caller. The message
+// of an error without a code may reveal internal details, e.g. : an address, only its code is sent
+func ToResponse(err error) (uint32, string, map[string][]byte) {
+	var coded *Error
+	if !errors.As(err, &coded) {
+		e := FromError(err)
+		return uint32(e.Code), "server error : " + e.Code.String(), map[string][]byte{}
+	}
+	e := coded
+
+	md := make(map[string][]byte, len(e.Metadata)+1)
+	for k, v := range e.Metadata {
+		md[k] = v
+	}
+	if len(e.Details) > 0 {
+		if data, err := EncodeDetails(e.Details); err == nil {
+			md[DetailsKey] = data
+		}
+	}
+
+	return uint32(e.Code), e.Message, md
+}
@@ -0,0 +1,65 @@
+package codes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFromResponseDecodesLegacyCodes(t *testing.T) {
+	tests := []struct {
+		code uint32
+		want Code
+	}{
+		{100, Internal},
+		{101, FailedPrecondition},
+		{201, Unimplemented},
+		{301, InvalidArgument},
+		{401, Unauthenticated},
+		{uint32(NotFound), NotFound},
+		// not sent by the servers built before the canonical codes
+		{103, Code(103)},
+		{202, Code(202)},
+	}
+
+	for _, tt := range tests {
+		if got := FromResponse(tt.code, "msg", nil).Code; got != tt.want {
+			t.Errorf("FromResponse(%d) code = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestToResponseHidesUncodedErrors(t *testing.T) {
+	code, msg, _ := ToResponse(errors.New("dial tcp 10.0.0.5:3306: connection refused"))
+	if Code(code) != Unknown {
+		t.Errorf("code = %v, want %v", Code(code), Unknown)
+	}
+	if strings.Contains(msg, "10.0.0.5") {
+		t.Errorf("message %q reveals the error detail", msg)
+	}
+
+	wrapped := fmt.Errorf("lookup: %w", New(NotFound, "no such user"))
+	code, msg, _ = ToResponse(wrapped)
+	if Code(code) != NotFound || msg != "no such user" {
+		t.Errorf("ToResponse() = %v %q, want %v %q", Code(code), msg, NotFound, "no such user")
+	}
+}
+
+func TestReasonsSurviveTheWire(t *testing.T) {
+	code, msg, md := ToResponse(ServerOverloadError)
+	err := FromResponse(code, msg, md)
+
+	if err.Code != Unavailable {
+		t.Errorf("code = %v, want %v", err.Code, Unavailable)
+	}
+	if !HasReason(err, ReasonServerOverloaded) {
+		t.Errorf("reason %s lost on the wire, details %v", ReasonServerOverloaded, err.Details)
+	}
+	if HasReason(err, ReasonConnectionClosing) {
+		t.Errorf("overload error matches the reason %s", ReasonConnectionClosing)
+	}
+	if HasReason(New(Unavailable, "down"), ReasonServerOverloaded) {
+		t.Error("error without details matches a reason")
+	}
+}
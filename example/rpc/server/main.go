@@ -16,13 +16,13 @@ import (
 
 func main() {
 	af := func(ctx context.Context) (context.Context, error) {
-		md := metadata.ServerMetadata(ctx)
+		md, ok := metadata.FromIncomingContext(ctx)
 
-		if len(md) == 0 {
+		if !ok || md.Len() == 0 {
 			return ctx, errors.New("token nil")
 		}
-		v := md["authorization"]
-		if string(v) != "Bearer token" {
+		v := md.Get("authorization")
+		if len(v) == 0 || v[0] != "Bearer token" {
 			return ctx, errors.New("token invalid")
 		}
 		return ctx, nil
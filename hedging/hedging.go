@@ -0,0 +1,189 @@
+// Package hedging sends extra copies of a request to other nodes when the first one is slow
+// to answer, takes the first successful response and cancels the rest
+package hedging
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codes"
+	"github.com/HuaTug/My-RPC/interceptor"
+	"github.com/HuaTug/My-RPC/selector"
+	"github.com/HuaTug/My-RPC/stream"
+)
+
+type hedger struct {
+	opts   *Options
+	budget *budget
+}
+
+type result struct {
+	rsp    interface{}
+	stream *stream.ClientStream // stream of the attempt, carrying its response metadata
+	err    error
+}
+
+// ClientInterceptor builds a client interceptor which hedges the methods configured by WithPolicy.
+// Every attempt of a call is sent to a distinct node whenever the selector can provide one
+func ClientInterceptor(opts ...Option) interceptor.ClientInterceptor {
+	o := &Options{
+		policies:    make(map[string]Policy),
+		budgetRatio: 0.1,
+		maxTokens:   10,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	h := &hedger{
+		opts: o,
+		budget: &budget{
+			tokens:    o.maxTokens,
+			ratio:     o.budgetRatio,
+			maxTokens: o.maxTokens,
+		},
+	}
+	return h.intercept
+}
+
+func (h *hedger) intercept(ctx context.Context, req, rsp interface{}, ivk interceptor.Invoker) error {
+
+	h.budget.deposit()
+
+	policy, ok := h.policy(ctx)
+	if !ok || policy.MaxAttempts < 2 || rsp == nil || reflect.TypeOf(rsp).Kind() != reflect.Ptr {
+		return ivk(ctx, req, rsp)
+	}
+
+	// cancel the attempts still in flight once the call returns
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ctx = selector.WithExclusion(ctx, selector.NewExclusion())
+
+	// buffered, so that attempts finishing after the call returned never block
+	results := make(chan result, policy.MaxAttempts)
+	rspType := reflect.TypeOf(rsp).Elem()
+
+	// every attempt has its own stream, so that only the returned response sets the response metadata
+	cs, _ := ctx.Value(stream.ClientStreamKey).(*stream.ClientStream)
+	launch := func() {
+		attemptRsp := reflect.New(rspType).Interface()
+		attemptStream := cs.Clone()
+		go func() {
+			err := ivk(stream.WithClientStream(ctx, attemptStream), req, attemptRsp)
+			results <- result{rsp: attemptRsp, stream: attemptStream, err: err}
+		}()
+	}
+
+	launch()
+	sent, pending := 1, 1
+
+	timer := time.NewTimer(policy.Delay)
+	defer timer.Stop()
+
+	var lastErr error
+	var shed bool // an attempt was rejected to protect the servers, no other one is sent
+	for {
+		select {
+		case <-timer.C:
+			if !shed && sent < policy.MaxAttempts && h.budget.withdraw() {
+				launch()
+				sent++
+				pending++
+				timer.Reset(policy.Delay)
+			}
+
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				reflect.ValueOf(rsp).Elem().Set(reflect.ValueOf(r.rsp).Elem())
+				cs.RspMetadata = r.stream.RspMetadata
+				return nil
+			}
+			lastErr = r.err
+			cs.RspMetadata = r.stream.RspMetadata
+
+			// another attempt would fail the same way, e.g. : InvalidArgument, PermissionDenied,
+			// or would add load to overloaded servers, e.g. : ResourceExhausted
+			if !retryable(r.err) {
+				return r.err
+			}
+
+			// a server shedding load or an open breaker gets no extra attempt, the attempts in flight may still win
+			if rejected(r.err) {
+				shed = true
+			}
+
+			// the node is unavailable, send the next attempt without waiting for the delay
+			if !shed && sent < policy.MaxAttempts && h.budget.withdraw() {
+				launch()
+				sent++
+				pending++
+				continue
+			}
+
+			if pending == 0 {
+				return lastErr
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// retryable reports whether a failed attempt may succeed on another node
+func retryable(err error) bool {
+	return codes.CodeOf(err) == codes.Unavailable
+}
+
+// rejected reports whether an attempt was refused to protect the servers, sending another one
+// right away would add to the load they shed
+func rejected(err error) bool {
+	return codes.HasReason(err, codes.ReasonServerOverloaded) ||
+		codes.HasReason(err, codes.ReasonCircuitOpen) ||
+		codes.HasReason(err, codes.ReasonNodeCircuitOpen)
+}
+
+func (h *hedger) policy(ctx context.Context) (Policy, bool) {
+	cs, ok := ctx.Value(stream.ClientStreamKey).(*stream.ClientStream)
+	if !ok {
+		return Policy{}, false
+	}
+
+	policy, ok := h.opts.policies[fmt.Sprintf("/%s/%s", cs.ServiceName, cs.Method)]
+	return policy, ok
+}
+
+// budget is a token bucket limiting the share of hedged attempts among all calls
+type budget struct {
+	mu        sync.Mutex
+	tokens    float64
+	ratio     float64
+	maxTokens float64
+}
+
+func (b *budget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += b.ratio
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+}
+
+func (b *budget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,146 @@
+package hedging
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codes"
+	"github.com/HuaTug/My-RPC/stream"
+)
+
+type echo struct {
+	Msg string
+}
+
+func callContext() context.Context {
+	ctx, cs := stream.NewClientStream(context.Background())
+	cs.WithServiceName("test.Greeter")
+	cs.WithMethod("SayHello")
+	return ctx
+}
+
+// invoker answers the attempts with the given functions, in order
+func invoker(attempts *int32, answers ...func(ctx context.Context, rsp *echo) error) func(context.Context, interface{}, interface{}) error {
+	return func(ctx context.Context, req, rsp interface{}) error {
+		n := atomic.AddInt32(attempts, 1)
+		return answers[n-1](ctx, rsp.(*echo))
+	}
+}
+
+func TestFirstCallIsHedgedAndWinnerMetadataKept(t *testing.T) {
+	intercept := ClientInterceptor(WithPolicy("/test.Greeter/SayHello", Policy{MaxAttempts: 2, Delay: 10 * time.Millisecond}))
+
+	var attempts int32
+	ivk := invoker(&attempts,
+		func(ctx context.Context, rsp *echo) error {
+			stream.GetClientStream(ctx).RspMetadata = map[string][]byte{"attempt": []byte("1")}
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		func(ctx context.Context, rsp *echo) error {
+			stream.GetClientStream(ctx).RspMetadata = map[string][]byte{"attempt": []byte("2")}
+			rsp.Msg = "hedged"
+			return nil
+		},
+	)
+
+	ctx := callContext()
+	rsp := &echo{}
+	if err := intercept(ctx, nil, rsp, ivk); err != nil {
+		t.Fatalf("intercept() error = %v", err)
+	}
+	if rsp.Msg != "hedged" {
+		t.Errorf("rsp = %q, want the response of the hedged attempt", rsp.Msg)
+	}
+	if got := string(stream.GetClientStream(ctx).RspMetadata["attempt"]); got != "2" {
+		t.Errorf("response metadata of attempt %q, want 2", got)
+	}
+}
+
+func TestNonRetryableErrorIsNotResent(t *testing.T) {
+	intercept := ClientInterceptor(WithPolicy("/test.Greeter/SayHello", Policy{MaxAttempts: 3, Delay: time.Hour}))
+
+	for _, code := range []codes.Code{codes.InvalidArgument, codes.PermissionDenied, codes.ResourceExhausted} {
+		var attempts int32
+		ivk := invoker(&attempts, func(ctx context.Context, rsp *echo) error {
+			return codes.New(code, "failed")
+		})
+
+		err := intercept(callContext(), nil, &echo{}, ivk)
+		if codes.CodeOf(err) != code {
+			t.Errorf("intercept() error = %v, want code %v", err, code)
+		}
+		if attempts != 1 {
+			t.Errorf("%v: %d attempts, want 1", code, attempts)
+		}
+	}
+}
+
+func TestUnavailableIsResentWithoutDelay(t *testing.T) {
+	intercept := ClientInterceptor(WithPolicy("/test.Greeter/SayHello", Policy{MaxAttempts: 3, Delay: time.Hour}))
+
+	var attempts int32
+	ivk := invoker(&attempts,
+		func(ctx context.Context, rsp *echo) error {
+			return codes.New(codes.Unavailable, "node down")
+		},
+		func(ctx context.Context, rsp *echo) error {
+			rsp.Msg = "ok"
+			return nil
+		},
+	)
+
+	rsp := &echo{}
+	if err := intercept(callContext(), nil, rsp, ivk); err != nil || rsp.Msg != "ok" {
+		t.Fatalf("intercept() = %q, %v, want ok", rsp.Msg, err)
+	}
+	if attempts != 2 {
+		t.Errorf("%d attempts, want 2", attempts)
+	}
+}
+
+func TestRejectionIsNotResent(t *testing.T) {
+	intercept := ClientInterceptor(WithPolicy("/test.Greeter/SayHello", Policy{MaxAttempts: 3, Delay: time.Hour}))
+
+	rejections := []*codes.Error{
+		codes.ServerOverloadError,
+		codes.CircuitBreakerOpenError,
+		codes.New(codes.Unavailable, "circuit breaker open for node").WithReason(codes.ReasonNodeCircuitOpen),
+	}
+	for _, rejection := range rejections {
+		var attempts int32
+		ivk := invoker(&attempts, func(ctx context.Context, rsp *echo) error {
+			return rejection
+		})
+
+		if err := intercept(callContext(), nil, &echo{}, ivk); err != rejection {
+			t.Errorf("intercept() error = %v, want %v", err, rejection)
+		}
+		if attempts != 1 {
+			t.Errorf("%v: %d attempts, want 1", rejection, attempts)
+		}
+	}
+}
+
+func TestRejectionWaitsForAttemptsInFlight(t *testing.T) {
+	intercept := ClientInterceptor(WithPolicy("/test.Greeter/SayHello", Policy{MaxAttempts: 3, Delay: 10 * time.Millisecond}))
+
+	var attempts int32
+	ivk := invoker(&attempts,
+		func(ctx context.Context, rsp *echo) error {
+			time.Sleep(50 * time.Millisecond)
+			rsp.Msg = "slow"
+			return nil
+		},
+		func(ctx context.Context, rsp *echo) error {
+			return codes.ServerOverloadError
+		},
+	)
+
+	rsp := &echo{}
+	if err := intercept(callContext(), nil, rsp, ivk); err != nil || rsp.Msg != "slow" {
+		t.Fatalf("intercept() = %q, %v, want the response of the first attempt", rsp.Msg, err)
+	}
+}
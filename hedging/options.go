@@ -0,0 +1,35 @@
+package hedging
+
+import "time"
+
+// Policy defines how the calls of one method are hedged
+type Policy struct {
+	MaxAttempts int           // total number of attempts including the original one, hedging needs at least 2
+	Delay       time.Duration // time to wait for an answer before sending the next attempt
+}
+
+// Options defines the hedging interceptor parameters
+type Options struct {
+	policies    map[string]Policy // service path e.g. : /test.Greeter/SayHello -> policy
+	budgetRatio float64           // hedge tokens earned by every call
+	maxTokens   float64           // upper limit of saved hedge tokens
+}
+
+type Option func(*Options)
+
+// WithPolicy enables hedging for a method, the method is given as its service path,
+// e.g. : /test.Greeter/SayHello. Only read-only (idempotent) methods should be hedged
+func WithPolicy(servicePath string, policy Policy) Option {
+	return func(o *Options) {
+		o.policies[servicePath] = policy
+	}
+}
+
+// WithBudget caps the hedging rate : the budget starts with maxTokens, every call earns ratio tokens, up to maxTokens,
+// and every hedged attempt spends one. E.g. a ratio of 0.1 allows at most 10% extra requests
+func WithBudget(ratio float64, maxTokens float64) Option {
+	return func(o *Options) {
+		o.budgetRatio = ratio
+		o.maxTokens = maxTokens
+	}
+}
@@ -0,0 +1,182 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// MD is a mapping from metadata keys to values. Keys are normalized to lower case
+type MD map[string][]string
+
+// ReservedPrefix prefixes the keys used by the framework itself, e.g. : gorpc-timeout.
+// Such keys can not be set through the outgoing and response metadata APIs
+const ReservedPrefix = "gorpc-"
+
+// New creates an MD from a map of single values
+func New(m map[string]string) MD {
+	md := make(MD, len(m))
+	for k, v := range m {
+		key := normalize(k)
+		md[key] = append(md[key], v)
+	}
+	return md
+}
+
+// Pairs creates an MD from key-value pairs, it panics when given an odd number of strings
+func Pairs(kv ...string) MD {
+	if len(kv)%2 == 1 {
+		panic(fmt.Sprintf("metadata: Pairs got an odd number of input pairs: %d", len(kv)))
+	}
+	md := make(MD, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		key := normalize(kv[i])
+		md[key] = append(md[key], kv[i+1])
+	}
+	return md
+}
+
+// Get returns the values of a key
+func (md MD) Get(key string) []string {
+	return md[normalize(key)]
+}
+
+// Set replaces the values of a key
+func (md MD) Set(key string, values ...string) {
+	if len(values) == 0 {
+		return
+	}
+	md[normalize(key)] = values
+}
+
+// Append adds values to a key
+func (md MD) Append(key string, values ...string) {
+	if len(values) == 0 {
+		return
+	}
+	key = normalize(key)
+	md[key] = append(md[key], values...)
+}
+
+// Delete removes a key
+func (md MD) Delete(key string) {
+	delete(md, normalize(key))
+}
+
+// Len returns the number of keys
+func (md MD) Len() int {
+	return len(md)
+}
+
+// Copy returns a deep copy of md
+func (md MD) Copy() MD {
+	out := make(MD, len(md))
+	for k, v := range md {
+		out[k] = append([]string(nil), v...)
+	}
+	return out
+}
+
+// Join merges several MDs, the values of the same key are concatenated
+func Join(mds ...MD) MD {
+	out := MD{}
+	for _, md := range mds {
+		for k, v := range md {
+			out[k] = append(out[k], v...)
+		}
+	}
+	return out
+}
+
+func normalize(key string) string {
+	return strings.ToLower(key)
+}
+
+// IsReserved reports whether a key is reserved to the framework
+func IsReserved(key string) bool {
+	return strings.HasPrefix(normalize(key), ReservedPrefix)
+}
+
+type mdOutgoingKey struct{}
+type mdIncomingKey struct{}
+
+// rawMD is stored in the context : the MD is never modified once attached, pairs appended
+// later are kept aside, so that contexts derived from the same parent do not see each other
+type rawMD struct {
+	md    MD
+	added [][]string
+}
+
+// NewOutgoingContext creates a new context with outgoing md attached, replacing any outgoing metadata
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, mdOutgoingKey{}, rawMD{md: md.Copy()})
+}
+
+// AppendToOutgoingContext returns a new context with the key-value pairs merged into the
+// existing outgoing metadata, it panics when given an odd number of strings
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	if len(kv)%2 == 1 {
+		panic(fmt.Sprintf("metadata: AppendToOutgoingContext got an odd number of input pairs: %d", len(kv)))
+	}
+
+	raw, _ := ctx.Value(mdOutgoingKey{}).(rawMD)
+	added := make([][]string, len(raw.added)+1)
+	copy(added, raw.added)
+	added[len(added)-1] = append([]string(nil), kv...)
+
+	return context.WithValue(ctx, mdOutgoingKey{}, rawMD{md: raw.md, added: added})
+}
+
+// FromOutgoingContext returns a copy of the outgoing metadata of the context
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	raw, ok := ctx.Value(mdOutgoingKey{}).(rawMD)
+	if !ok {
+		return nil, false
+	}
+
+	out := raw.md.Copy()
+	for _, kv := range raw.added {
+		for i := 0; i < len(kv); i += 2 {
+			out.Append(kv[i], kv[i+1])
+		}
+	}
+	return out, true
+}
+
+// NewIncomingContext creates a new context with incoming md attached, it is used by the server
+func NewIncomingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, mdIncomingKey{}, md)
+}
+
+// FromIncomingContext returns a copy of the metadata received with the request
+func FromIncomingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(mdIncomingKey{}).(MD)
+	if !ok {
+		return nil, false
+	}
+	return md.Copy(), true
+}
+
+// PropagateIncoming copies the selected keys of the incoming metadata into the outgoing metadata,
+// so that a handler forwards them to the downstream calls. Reserved keys are never propagated
+func PropagateIncoming(ctx context.Context, keys ...string) context.Context {
+	md, ok := ctx.Value(mdIncomingKey{}).(MD)
+	if !ok {
+		return ctx
+	}
+
+	var kv []string
+	for _, key := range keys {
+		if IsReserved(key) {
+			continue
+		}
+		for _, v := range md.Get(key) {
+			kv = append(kv, normalize(key), v)
+		}
+	}
+
+	if len(kv) == 0 {
+		return ctx
+	}
+	return AppendToOutgoingContext(ctx, kv...)
+}
@@ -0,0 +1,89 @@
+package metadata
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestKeysAreLowerCased(t *testing.T) {
+	md := Pairs("X-Request-ID", "1", "x-request-id", "2")
+	md.Append("X-REQUEST-ID", "3")
+
+	if got := md.Get("x-Request-Id"); !reflect.DeepEqual(got, []string{"1", "2", "3"}) {
+		t.Errorf("Get() = %v, want the values of every casing of the key", got)
+	}
+	if _, ok := md["X-Request-ID"]; ok || md.Len() != 1 {
+		t.Errorf("md = %v, want a single lower case key", md)
+	}
+
+	md = New(map[string]string{"Tenant": "a"})
+	md.Set("TENANT", "b")
+	if got := md.Get("tenant"); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("Get() after Set() = %v, want [b]", got)
+	}
+	md.Delete("Tenant")
+	if md.Len() != 0 {
+		t.Errorf("md = %v after Delete(), want empty", md)
+	}
+}
+
+func TestIsReserved(t *testing.T) {
+	for key, want := range map[string]bool{
+		"gorpc-timeout": true,
+		"GoRPC-Trailer": true,
+		"gorpc":         false,
+		"x-gorpc-id":    false,
+	} {
+		if got := IsReserved(key); got != want {
+			t.Errorf("IsReserved(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestOutgoingContextsAreIsolated(t *testing.T) {
+	parent := NewOutgoingContext(context.Background(), Pairs("k", "parent"))
+	a := AppendToOutgoingContext(parent, "k", "a")
+	b := AppendToOutgoingContext(parent, "K", "b")
+
+	mdA, _ := FromOutgoingContext(a)
+	mdB, _ := FromOutgoingContext(b)
+	if got := mdA.Get("k"); !reflect.DeepEqual(got, []string{"parent", "a"}) {
+		t.Errorf("outgoing metadata of a = %v, want [parent a]", got)
+	}
+	if got := mdB.Get("k"); !reflect.DeepEqual(got, []string{"parent", "b"}) {
+		t.Errorf("outgoing metadata of b = %v, want [parent b]", got)
+	}
+
+	// the returned metadata is a copy
+	mdA.Set("k", "changed")
+	if md, _ := FromOutgoingContext(a); md.Get("k")[0] != "parent" {
+		t.Error("outgoing metadata changed through the returned copy")
+	}
+}
+
+func TestPropagateIncoming(t *testing.T) {
+	incoming := MD{
+		"x-request-id":  {"1", "2"},
+		"tenant":        {"a"},
+		"authorization": {"Bearer token"},
+		"gorpc-timeout": {"100"},
+	}
+	ctx := NewIncomingContext(context.Background(), incoming)
+	ctx = PropagateIncoming(ctx, "X-Request-ID", "tenant", "gorpc-timeout", "missing")
+
+	out, ok := FromOutgoingContext(ctx)
+	if !ok {
+		t.Fatal("no outgoing metadata")
+	}
+	want := MD{"x-request-id": {"1", "2"}, "tenant": {"a"}}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("outgoing metadata = %v, want only the selected keys which are not reserved %v", out, want)
+	}
+
+	// nothing to propagate
+	ctx = context.Background()
+	if got := PropagateIncoming(ctx, "tenant"); got != ctx {
+		t.Error("context without incoming metadata changed")
+	}
+}
@@ -2,38 +2,49 @@ package metadata
 
 import "context"
 
+// TimeoutKey is the request metadata key carrying the time left before the caller deadline, in milliseconds
+const TimeoutKey = "gorpc-timeout"
+
+// RequestIDKey is the request metadata key carrying the request ID set by the caller, if any
+const RequestIDKey = "x-request-id"
+
 type clientMD struct{}
 type serverMD struct{}
 
 type clientMetadata map[string][]byte
 type serverMetadata map[string][]byte
 
-// ClientMetadata creates a new context with key-value pairs attached.
+// ClientMetadata returns the metadata attached by WithClientMetadata.
+//
+// Deprecated: use NewOutgoingContext or AppendToOutgoingContext. When no metadata is attached,
+// the returned map is not linked to the context and the values set on it are lost
 func ClientMetadata(ctx context.Context) clientMetadata {
 	if md, ok := ctx.Value(clientMD{}).(clientMetadata); ok {
 		return md
 	}
-	md := make(map[string][]byte)
-	WithClientMetadata(ctx, md)
-	return md
+	return make(map[string][]byte)
 }
 
 // WithClientMetadata creates a new context with the specified metadata
+//
+// Deprecated: use NewOutgoingContext or AppendToOutgoingContext
 func WithClientMetadata(ctx context.Context, metadata map[string][]byte) context.Context {
 	return context.WithValue(ctx, clientMD{}, clientMetadata(metadata))
 }
 
-// ServerMetadata creates a new context with key-value pairs attached.
+// ServerMetadata returns the raw metadata received with the request.
+//
+// Deprecated: use FromIncomingContext
 func ServerMetadata(ctx context.Context) serverMetadata {
 	if md, ok := ctx.Value(serverMD{}).(serverMetadata); ok {
 		return md
 	}
-	md := make(map[string][]byte)
-	WithServerMetadata(ctx, md)
-	return md
+	return make(map[string][]byte)
 }
 
 // WithServerMetadata creates a new context with the specified metadata
+//
+// Deprecated: use NewIncomingContext
 func WithServerMetadata(ctx context.Context, metadata map[string][]byte) context.Context {
 	return context.WithValue(ctx, serverMD{}, serverMetadata(metadata))
 }
@@ -0,0 +1,107 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+type responseMD struct{}
+
+// ErrNoResponseMetadata is returned when setting response metadata outside of a server call
+var ErrNoResponseMetadata = errors.New("no response metadata in context")
+
+// ResponseMetadata collects the headers and trailers sent back to the caller with the response
+type ResponseMetadata struct {
+	mu      sync.Mutex
+	header  map[string][]byte
+	trailer map[string][]byte
+}
+
+// WithResponseMetadata creates a new context collecting the response metadata of a call
+func WithResponseMetadata(ctx context.Context) (context.Context, *ResponseMetadata) {
+	rmd := &ResponseMetadata{
+		header:  make(map[string][]byte),
+		trailer: make(map[string][]byte),
+	}
+	return context.WithValue(ctx, responseMD{}, rmd), rmd
+}
+
+// SetHeader sets a response header, it can be called by handlers and server interceptors
+func SetHeader(ctx context.Context, key, value string) error {
+	rmd, ok := ctx.Value(responseMD{}).(*ResponseMetadata)
+	if !ok {
+		return ErrNoResponseMetadata
+	}
+
+	if IsReserved(key) {
+		return ErrReservedKey
+	}
+
+	rmd.mu.Lock()
+	defer rmd.mu.Unlock()
+	rmd.header[normalize(key)] = []byte(value)
+	return nil
+}
+
+// SetTrailer sets a response trailer. Trailers are applied after the headers and override
+// a header of the same key, e.g. : a value only known once the handler returned
+func SetTrailer(ctx context.Context, key, value string) error {
+	rmd, ok := ctx.Value(responseMD{}).(*ResponseMetadata)
+	if !ok {
+		return ErrNoResponseMetadata
+	}
+
+	if IsReserved(key) {
+		return ErrReservedKey
+	}
+
+	rmd.mu.Lock()
+	defer rmd.mu.Unlock()
+	rmd.trailer[normalize(key)] = []byte(value)
+	return nil
+}
+
+// TrailerPrefix prefixes the keys of the trailers in the response, so that callers can tell them from the headers
+const TrailerPrefix = ReservedPrefix + "trailer-"
+
+// Metadata returns the headers and the trailers as sent in the response, the trailer keys are
+// prefixed by TrailerPrefix
+func (r *ResponseMetadata) Metadata() map[string][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	md := make(map[string][]byte, len(r.header)+len(r.trailer))
+	for k, v := range r.header {
+		md[k] = v
+	}
+	for k, v := range r.trailer {
+		md[TrailerPrefix+k] = v
+	}
+	return md
+}
+
+// SplitResponse separates the headers and the trailers of the metadata of a response
+func SplitResponse(md map[string][]byte) (header map[string][]byte, trailer map[string][]byte) {
+	header = make(map[string][]byte, len(md))
+	trailer = make(map[string][]byte)
+	for k, v := range md {
+		if strings.HasPrefix(k, TrailerPrefix) {
+			trailer[strings.TrimPrefix(k, TrailerPrefix)] = v
+			continue
+		}
+		header[k] = v
+	}
+	return header, trailer
+}
+
+// MergeResponse returns the headers of the metadata of a response merged with its trailers,
+// a trailer overrides a header of the same key
+func MergeResponse(md map[string][]byte) map[string][]byte {
+	header, trailer := SplitResponse(md)
+	for k, v := range trailer {
+		header[k] = v
+	}
+	return header
+}
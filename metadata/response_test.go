@@ -0,0 +1,60 @@
+package metadata
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestResponseMetadata(t *testing.T) {
+	if err := SetHeader(context.Background(), "k", "v"); err != ErrNoResponseMetadata {
+		t.Errorf("SetHeader() outside of a call = %v, want %v", err, ErrNoResponseMetadata)
+	}
+
+	ctx, rmd := WithResponseMetadata(context.Background())
+	if err := SetHeader(ctx, "gorpc-status", "v"); err != ErrReservedKey {
+		t.Errorf("SetHeader() of a reserved key = %v, want %v", err, ErrReservedKey)
+	}
+	if err := SetTrailer(ctx, "Gorpc-Status", "v"); err != ErrReservedKey {
+		t.Errorf("SetTrailer() of a reserved key = %v, want %v", err, ErrReservedKey)
+	}
+
+	SetHeader(ctx, "Version", "1")
+	SetHeader(ctx, "status", "pending")
+	SetTrailer(ctx, "Status", "done")
+
+	want := map[string][]byte{
+		"version":                []byte("1"),
+		"status":                 []byte("pending"),
+		TrailerPrefix + "status": []byte("done"),
+	}
+	if got := rmd.Metadata(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Metadata() = %q, want %q", got, want)
+	}
+}
+
+func TestSplitAndMergeResponse(t *testing.T) {
+	md := map[string][]byte{
+		"version":                []byte("1"),
+		"status":                 []byte("pending"),
+		TrailerPrefix + "status": []byte("done"),
+		TrailerPrefix + "cost":   []byte("3"),
+	}
+
+	header, trailer := SplitResponse(md)
+	if want := map[string][]byte{"version": []byte("1"), "status": []byte("pending")}; !reflect.DeepEqual(header, want) {
+		t.Errorf("header = %q, want %q", header, want)
+	}
+	if want := map[string][]byte{"status": []byte("done"), "cost": []byte("3")}; !reflect.DeepEqual(trailer, want) {
+		t.Errorf("trailer = %q, want %q", trailer, want)
+	}
+
+	// a trailer overrides the header of the same key
+	want := map[string][]byte{"version": []byte("1"), "status": []byte("done"), "cost": []byte("3")}
+	if got := MergeResponse(md); !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeResponse() = %q, want %q", got, want)
+	}
+	if len(md) != 4 {
+		t.Error("MergeResponse() changed the response metadata")
+	}
+}
@@ -0,0 +1,54 @@
+package metadata
+
+import (
+	"errors"
+	"strings"
+)
+
+// DefaultMaxSize is the default limit of the metadata size of a request, keys and values included
+const DefaultMaxSize = 8 * 1024
+
+// valueSeparator separates the values of a multi-value key on the wire
+const valueSeparator = "\x00"
+
+var (
+	ErrReservedKey  = errors.New("metadata: key uses the reserved prefix " + ReservedPrefix)
+	ErrTooLarge     = errors.New("metadata: size exceeds the limit")
+	ErrInvalidValue = errors.New("metadata: value contains a NUL byte")
+)
+
+// Encode converts md into its wire format, reserved keys are rejected
+func Encode(md MD) (map[string][]byte, error) {
+	raw := make(map[string][]byte, len(md))
+	for k, values := range md {
+		if IsReserved(k) {
+			return nil, ErrReservedKey
+		}
+		for _, v := range values {
+			if strings.Contains(v, valueSeparator) {
+				return nil, ErrInvalidValue
+			}
+		}
+		raw[normalize(k)] = []byte(strings.Join(values, valueSeparator))
+	}
+	return raw, nil
+}
+
+// Decode converts wire metadata into an MD
+func Decode(raw map[string][]byte) MD {
+	md := make(MD, len(raw))
+	for k, v := range raw {
+		key := normalize(k)
+		md[key] = append(md[key], strings.Split(string(v), valueSeparator)...)
+	}
+	return md
+}
+
+// Size returns the size of wire metadata, keys and values included
+func Size(raw map[string][]byte) int {
+	size := 0
+	for k, v := range raw {
+		size += len(k) + len(v)
+	}
+	return size
+}
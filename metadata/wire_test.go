@@ -0,0 +1,52 @@
+package metadata
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	md := MD{
+		"x-request-id": {"1"},
+		"Tenant":       {"a", "b", ""},
+		"empty":        {""},
+	}
+
+	raw, err := Encode(md)
+	if err != nil {
+		t.Fatalf("Encode() = %v", err)
+	}
+	// the values of a key are joined by NUL bytes under the lower case key
+	if got := string(raw["tenant"]); got != "a\x00b\x00" {
+		t.Errorf("wire value = %q, want %q", got, "a\x00b\x00")
+	}
+
+	want := MD{
+		"x-request-id": {"1"},
+		"tenant":       {"a", "b", ""},
+		"empty":        {""},
+	}
+	if got := Decode(raw); !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode(Encode()) = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeRejects(t *testing.T) {
+	if _, err := Encode(MD{"GoRPC-Timeout": {"1"}}); err != ErrReservedKey {
+		t.Errorf("Encode() of a reserved key = %v, want %v", err, ErrReservedKey)
+	}
+	if _, err := Encode(MD{"k": {"a\x00b"}}); err != ErrInvalidValue {
+		t.Errorf("Encode() of a value with a NUL byte = %v, want %v", err, ErrInvalidValue)
+	}
+}
+
+func TestSize(t *testing.T) {
+	raw, err := Encode(MD{"key": {strings.Repeat("v", DefaultMaxSize-3)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Size(raw); got != DefaultMaxSize {
+		t.Errorf("Size() = %d, want %d", got, DefaultMaxSize)
+	}
+}
@@ -0,0 +1,80 @@
+// Package metrics provides counters and gauges for the framework. They are published
+// through expvar, e.g. : on /debug/vars of http.DefaultServeMux
+package metrics
+
+import (
+	"expvar"
+	"sync"
+)
+
+var (
+	mu       sync.Mutex
+	counters = make(map[string]*Counter)
+	gauges   = make(map[string]*Gauge)
+)
+
+// Counter is a monotonically increasing metric
+type Counter struct {
+	v *expvar.Int
+}
+
+// Gauge is a metric which can go up and down
+type Gauge struct {
+	v *expvar.Float
+}
+
+// GetCounter returns the counter of a name, creating it when needed
+func GetCounter(name string) *Counter {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if c, ok := counters[name]; ok {
+		return c
+	}
+	c := &Counter{v: expvar.NewInt(name)}
+	counters[name] = c
+	return c
+}
+
+// GetGauge returns the gauge of a name, creating it when needed
+func GetGauge(name string) *Gauge {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if g, ok := gauges[name]; ok {
+		return g
+	}
+	g := &Gauge{v: expvar.NewFloat(name)}
+	gauges[name] = g
+	return g
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add increments the counter by delta
+func (c *Counter) Add(delta int64) {
+	c.v.Add(delta)
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() int64 {
+	return c.v.Value()
+}
+
+// Set sets the gauge value
+func (g *Gauge) Set(value float64) {
+	g.v.Set(value)
+}
+
+// Add adds delta to the gauge value
+func (g *Gauge) Add(delta float64) {
+	g.v.Add(delta)
+}
+
+// Value returns the current value of the gauge
+func (g *Gauge) Value() float64 {
+	return g.v.Value()
+}
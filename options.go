@@ -1,9 +1,13 @@
 package rpcdemo
 
 import (
+	"context"
+	"os"
 	"time"
 
+	"github.com/HuaTug/My-RPC/auth"
 	"github.com/HuaTug/My-RPC/interceptor"
+	"github.com/HuaTug/My-RPC/overload"
 )
 
 // ServerOptions defines the server serve parameters
@@ -14,13 +18,34 @@ type ServerOptions struct {
 	timeout           time.Duration // timeout
 	serializationType string        // serialization type, default: proto
 
-	selectorSvrAddr string   // service discovery server address, required when using the third-party service discovery plugin
-	tracingSvrAddr  string   // tracing plugin server address, required when using the third-party tracing plugin
-	tracingSpanName string   // tracing span name, required when using the third-party tracing plugin
-	pluginNames     []string // plugin name
-	interceptors    []interceptor.ServerInterceptor
+	selectorSvrAddr    string   // service discovery server address, required when using the third-party service discovery plugin
+	tracingSvrAddr     string   // tracing plugin server address, required when using the third-party tracing plugin
+	tracingSpanName    string   // tracing span name, required when using the third-party tracing plugin
+	pluginNames        []string // plugin name
+	interceptors       []interceptor.ServerInterceptor
+	overloadLimiter    *overload.Limiter  // sheds excess load before payloads are deserialized
+	maxMetadataSize    int                // request metadata size limit, default: metadata.DefaultMaxSize
+	panicHandler       PanicHandler       // called with the panics recovered in the handlers
+	workers            int                // size of the request worker pool, 0 runs every request on its own goroutine
+	queueSize          int                // number of requests waiting for a worker
+	maxQueueWait       time.Duration      // requests waiting longer for a worker are dropped
+	maxConns           int                // maximum concurrent connections
+	maxConnsPerIP      int                // maximum concurrent connections of a remote IP
+	maxConnRequests    int                // requests served by a connection before it is gracefully closed
+	maxConnAge         time.Duration      // lifetime of a connection before it is gracefully closed
+	allowCIDRs         []string           // networks allowed to connect
+	denyCIDRs          []string           // networks denied to connect
+	readBufferSize     int                // udp socket receive buffer size
+	maxPacketsInFlight int                // udp packets handled concurrently
+	udpDedupWindow     time.Duration      // how long the udp responses are kept for retransmitted requests
+	socketMode         os.FileMode        // unix socket file mode
+	socketGroup        string             // unix socket file group, name or gid
+	transportAuth      auth.TransportAuth // authenticates the accepted connections, e.g. : auth.NewServerTLSFromFile
 }
 
+// PanicHandler reports a panic recovered in a handler or an interceptor, e.g. : to an error tracking system
+type PanicHandler func(ctx context.Context, requestID string, p interface{}, stack []byte)
+
 type ServerOption func(*ServerOptions)
 
 func WithAddress(address string) ServerOption {
@@ -82,3 +107,128 @@ func WithTracingSpanName(name string) ServerOption {
 		o.tracingSpanName = name
 	}
 }
+
+// WithOverloadLimiter enables adaptive load shedding, requests over the limit are rejected
+// with codes.ServerOverloadError, low priority ones first
+func WithOverloadLimiter(limiter *overload.Limiter) ServerOption {
+	return func(o *ServerOptions) {
+		o.overloadLimiter = limiter
+	}
+}
+
+// WithMaxMetadataSize limits the size of the request metadata, keys and values included
+func WithMaxMetadataSize(size int) ServerOption {
+	return func(o *ServerOptions) {
+		o.maxMetadataSize = size
+	}
+}
+
+// WithPanicHandler sets a hook called with the panics recovered in the handlers and interceptors
+func WithPanicHandler(handler PanicHandler) ServerOption {
+	return func(o *ServerOptions) {
+		o.panicHandler = handler
+	}
+}
+
+// WithWorkerPool runs the requests on a bounded number of workers, the requests which do not fit
+// in the queue are rejected with codes.ServerOverloadError
+func WithWorkerPool(workers int, queueSize int) ServerOption {
+	return func(o *ServerOptions) {
+		o.workers = workers
+		o.queueSize = queueSize
+	}
+}
+
+// WithMaxQueueWait drops the requests waiting longer than maxQueueWait for a worker
+func WithMaxQueueWait(maxQueueWait time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.maxQueueWait = maxQueueWait
+	}
+}
+
+// WithMaxConns limits the number of concurrent connections, connections over the limit are closed once accepted
+func WithMaxConns(maxConns int) ServerOption {
+	return func(o *ServerOptions) {
+		o.maxConns = maxConns
+	}
+}
+
+// WithMaxConnsPerIP limits the number of concurrent connections of a single remote IP
+func WithMaxConnsPerIP(maxConnsPerIP int) ServerOption {
+	return func(o *ServerOptions) {
+		o.maxConnsPerIP = maxConnsPerIP
+	}
+}
+
+// WithMaxConnRequests gracefully closes the connections having served maxConnRequests requests,
+// the clients move to new connections, which rebalances them across the servers
+func WithMaxConnRequests(maxConnRequests int) ServerOption {
+	return func(o *ServerOptions) {
+		o.maxConnRequests = maxConnRequests
+	}
+}
+
+// WithMaxConnAge gracefully closes the connections older than maxConnAge
+func WithMaxConnAge(maxConnAge time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.maxConnAge = maxConnAge
+	}
+}
+
+// WithAllowCIDRs only accepts the peers of the given networks, e.g. : 10.0.0.0/8
+func WithAllowCIDRs(cidrs ...string) ServerOption {
+	return func(o *ServerOptions) {
+		o.allowCIDRs = cidrs
+	}
+}
+
+// WithDenyCIDRs rejects the peers of the given networks, even if they are allowed by WithAllowCIDRs
+func WithDenyCIDRs(cidrs ...string) ServerOption {
+	return func(o *ServerOptions) {
+		o.denyCIDRs = cidrs
+	}
+}
+
+// WithReadBufferSize sets the receive buffer size (SO_RCVBUF) of the udp socket, so that bursts
+// of packets are not dropped while all the handlers are busy
+func WithReadBufferSize(size int) ServerOption {
+	return func(o *ServerOptions) {
+		o.readBufferSize = size
+	}
+}
+
+// WithMaxPacketsInFlight bounds the udp packets handled concurrently, the next packets wait in the socket receive buffer
+func WithMaxPacketsInFlight(maxPacketsInFlight int) ServerOption {
+	return func(o *ServerOptions) {
+		o.maxPacketsInFlight = maxPacketsInFlight
+	}
+}
+
+// WithUdpDedupWindow sets how long the udp responses are kept : a request retransmitted within
+// the window is not handled again, its response is sent again instead
+func WithUdpDedupWindow(window time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.udpDedupWindow = window
+	}
+}
+
+// WithSocketMode sets the mode of the unix socket file, e.g. : 0660
+func WithSocketMode(mode os.FileMode) ServerOption {
+	return func(o *ServerOptions) {
+		o.socketMode = mode
+	}
+}
+
+// WithSocketGroup sets the group of the unix socket file, a group name or a gid
+func WithSocketGroup(group string) ServerOption {
+	return func(o *ServerOptions) {
+		o.socketGroup = group
+	}
+}
+
+// WithTransportAuth secures the accepted connections, e.g. : with TLS
+func WithTransportAuth(transportAuth auth.TransportAuth) ServerOption {
+	return func(o *ServerOptions) {
+		o.transportAuth = transportAuth
+	}
+}
@@ -0,0 +1,44 @@
+package overload
+
+import "time"
+
+// Options defines the adaptive concurrency limiter parameters
+type Options struct {
+	initialLimit  int           // concurrency limit at start
+	minLimit      int           // lower bound of the limit
+	maxLimit      int           // upper bound of the limit
+	targetLatency time.Duration // requests slower than it are a sign of congestion
+	backoff       float64       // multiplicative decrease factor applied on congestion
+}
+
+type Option func(*Options)
+
+func WithInitialLimit(limit int) Option {
+	return func(o *Options) {
+		o.initialLimit = limit
+	}
+}
+
+func WithMinLimit(limit int) Option {
+	return func(o *Options) {
+		o.minLimit = limit
+	}
+}
+
+func WithMaxLimit(limit int) Option {
+	return func(o *Options) {
+		o.maxLimit = limit
+	}
+}
+
+func WithTargetLatency(latency time.Duration) Option {
+	return func(o *Options) {
+		o.targetLatency = latency
+	}
+}
+
+func WithBackoff(backoff float64) Option {
+	return func(o *Options) {
+		o.backoff = backoff
+	}
+}
@@ -0,0 +1,141 @@
+// Package overload sheds excess server load with an adaptive (AIMD) concurrency limit.
+// The limit grows additively while requests complete within the target latency and shrinks
+// multiplicatively when they do not, low priority traffic being rejected first
+package overload
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Priority is the importance of a request, critical requests are shed last
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+	PriorityCritical
+)
+
+// PriorityKey is the request metadata key carrying the priority hint, e.g. : low、normal、high、critical
+const PriorityKey = "priority"
+
+// share of the limit each priority may use
+var shares = map[Priority]float64{
+	PriorityLow:      0.5,
+	PriorityNormal:   0.75,
+	PriorityHigh:     0.9,
+	PriorityCritical: 1,
+}
+
+// ParsePriority parses a priority hint, unknown hints are treated as normal priority
+func ParsePriority(hint string) Priority {
+	switch strings.ToLower(hint) {
+	case "low":
+		return PriorityLow
+	case "high":
+		return PriorityHigh
+	case "critical":
+		return PriorityCritical
+	default:
+		return PriorityNormal
+	}
+}
+
+// Limiter is an adaptive concurrency limiter
+type Limiter struct {
+	opts *Options
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+}
+
+// NewLimiter creates a Limiter
+func NewLimiter(opt ...Option) *Limiter {
+	// default options
+	opts := &Options{
+		initialLimit:  100,
+		minLimit:      10,
+		maxLimit:      1000,
+		targetLatency: 100 * time.Millisecond,
+		backoff:       0.9,
+	}
+	for _, o := range opt {
+		o(opts)
+	}
+
+	return &Limiter{
+		opts:  opts,
+		limit: float64(opts.initialLimit),
+	}
+}
+
+// Acquire admits a request of the given priority. When admitted, the returned release function
+// must be called with the request latency and error once the request completes
+func (l *Limiter) Acquire(priority Priority) (release func(time.Duration, error), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	share, exist := shares[priority]
+	if !exist {
+		share = shares[PriorityNormal]
+	}
+
+	allowed := l.limit * share
+	if allowed < 1 {
+		allowed = 1
+	}
+	if float64(l.inflight) >= allowed {
+		return nil, false
+	}
+
+	l.inflight++
+
+	var once sync.Once
+	return func(latency time.Duration, err error) {
+		once.Do(func() {
+			l.release(latency, err)
+		})
+	}, true
+}
+
+func (l *Limiter) release(latency time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inflight--
+
+	if latency > l.opts.targetLatency || errors.Is(err, context.DeadlineExceeded) {
+		// multiplicative decrease
+		l.limit *= l.opts.backoff
+		if l.limit < float64(l.opts.minLimit) {
+			l.limit = float64(l.opts.minLimit)
+		}
+		return
+	}
+
+	// additive increase, about one per limit requests
+	l.limit += 1 / l.limit
+	if l.limit > float64(l.opts.maxLimit) {
+		l.limit = float64(l.opts.maxLimit)
+	}
+}
+
+// Limit returns the current concurrency limit
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns the number of admitted requests still running
+func (l *Limiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight
+}
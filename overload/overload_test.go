@@ -0,0 +1,147 @@
+package overload
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// admitted returns how many requests of a priority are admitted in a row, they are not released
+func admitted(l *Limiter, priority Priority) int {
+	n := 0
+	for ; n < 10000; n++ {
+		if _, ok := l.Acquire(priority); !ok {
+			break
+		}
+	}
+	return n
+}
+
+// sample admits a request and releases it with the given latency and error
+func sample(t *testing.T, l *Limiter, latency time.Duration, err error) {
+	release, ok := l.Acquire(PriorityCritical)
+	if !ok {
+		t.Fatal("request rejected")
+	}
+	release(latency, err)
+}
+
+func TestAdditiveIncrease(t *testing.T) {
+	l := NewLimiter(WithInitialLimit(10), WithTargetLatency(100*time.Millisecond))
+
+	want := 10.0
+	for i := 0; i < 25; i++ {
+		sample(t, l, 50*time.Millisecond, nil)
+		want += 1 / want
+	}
+
+	// about one more per limit requests within the target
+	if math.Abs(l.limit-want) > 1e-9 || l.Limit() != 12 {
+		t.Errorf("limit = %v, want %v", l.limit, want)
+	}
+	if l.InFlight() != 0 {
+		t.Errorf("%d requests in flight, want 0", l.InFlight())
+	}
+}
+
+func TestMultiplicativeDecrease(t *testing.T) {
+	l := NewLimiter(WithInitialLimit(100), WithTargetLatency(100*time.Millisecond), WithBackoff(0.5))
+
+	sample(t, l, 150*time.Millisecond, nil)
+	if l.Limit() != 50 {
+		t.Errorf("limit after a slow request = %d, want 50", l.Limit())
+	}
+
+	// a request which timed out is congestion, however long it took
+	sample(t, l, time.Millisecond, context.DeadlineExceeded)
+	if l.Limit() != 25 {
+		t.Errorf("limit after a timed out request = %d, want 25", l.Limit())
+	}
+
+	// other errors are not congestion
+	sample(t, l, time.Millisecond, errors.New("invalid request"))
+	if l.Limit() != 25 {
+		t.Errorf("limit after a failed request = %d, want 25", l.Limit())
+	}
+}
+
+func TestLimitBounds(t *testing.T) {
+	l := NewLimiter(WithInitialLimit(20), WithMinLimit(10), WithMaxLimit(21), WithBackoff(0.5))
+
+	for i := 0; i < 5; i++ {
+		sample(t, l, time.Second, nil)
+	}
+	if l.Limit() != 10 {
+		t.Errorf("limit = %d, want the min limit 10", l.Limit())
+	}
+
+	for i := 0; i < 500; i++ {
+		sample(t, l, time.Millisecond, nil)
+	}
+	if l.Limit() != 21 {
+		t.Errorf("limit = %d, want the max limit 21", l.Limit())
+	}
+}
+
+func TestPriorityShares(t *testing.T) {
+	tests := []struct {
+		priority Priority
+		want     int
+	}{
+		{PriorityLow, 50},
+		{PriorityNormal, 75},
+		{PriorityHigh, 90},
+		{PriorityCritical, 100},
+		{Priority(42), 75}, // unknown priorities are normal
+	}
+	for _, tt := range tests {
+		if n := admitted(NewLimiter(WithInitialLimit(100)), tt.priority); n != tt.want {
+			t.Errorf("priority %d: %d requests admitted, want %d", tt.priority, n, tt.want)
+		}
+	}
+
+	// a limit below 2 still admits a low priority request
+	if n := admitted(NewLimiter(WithInitialLimit(1), WithMinLimit(1)), PriorityLow); n != 1 {
+		t.Errorf("%d low priority requests admitted under a limit of 1, want 1", n)
+	}
+}
+
+func TestLowPriorityShedFirst(t *testing.T) {
+	l := NewLimiter(WithInitialLimit(100))
+
+	var releases []func(time.Duration, error)
+	for i := 0; i < 60; i++ {
+		release, ok := l.Acquire(PriorityNormal)
+		if !ok {
+			t.Fatalf("normal priority request %d rejected", i)
+		}
+		releases = append(releases, release)
+	}
+
+	if _, ok := l.Acquire(PriorityLow); ok {
+		t.Error("low priority request admitted above its share")
+	}
+	if n := admitted(l, PriorityNormal); n != 15 {
+		t.Errorf("%d more normal priority requests admitted, want 15", n)
+	}
+	if n := admitted(l, PriorityHigh); n != 15 {
+		t.Errorf("%d high priority requests admitted, want 15", n)
+	}
+	if n := admitted(l, PriorityCritical); n != 10 {
+		t.Errorf("%d critical requests admitted, want 10", n)
+	}
+
+	// released slots admit low priority requests again, a release counts once
+	for _, release := range releases {
+		release(time.Millisecond, nil)
+		release(time.Millisecond, nil)
+	}
+	if l.InFlight() != 40 {
+		t.Errorf("%d requests in flight, want 40", l.InFlight())
+	}
+	if _, ok := l.Acquire(PriorityLow); !ok {
+		t.Error("low priority request rejected below its share")
+	}
+}
@@ -0,0 +1,30 @@
+// Package peer exposes the information of the client of a request to the handlers and interceptors
+package peer
+
+import (
+	"context"
+	"net"
+
+	"github.com/HuaTug/My-RPC/auth"
+)
+
+// Peer describes the client end of the connection a request was received on
+type Peer struct {
+	Addr      net.Addr      // address of the client
+	LocalAddr net.Addr      // address the request was received on
+	AuthInfo  auth.AuthInfo // authentication of the connection by the TransportAuth, nil without TransportAuth
+	ConnID    uint64        // ID of the connection within the server process, 0 for udp which has no connection
+}
+
+type peerKey struct{}
+
+// NewContext returns a context carrying the peer
+func NewContext(ctx context.Context, p *Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+// FromContext returns the peer of a request, ok is false outside of the server handlers
+func FromContext(ctx context.Context) (p *Peer, ok bool) {
+	p, ok = ctx.Value(peerKey{}).(*Peer)
+	return
+}
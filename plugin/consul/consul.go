@@ -1,6 +1,8 @@
 package consul
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -16,7 +18,6 @@ type Consul struct {
 	opts         *plugin.Options
 	client       *api.Client
 	config       *api.Config
-	balancerName string // load balancing mode, including random, polling, weighted polling, consistent hash, etc
 	writeOptions *api.WriteOptions
 	queryOptions *api.QueryOptions
 }
@@ -52,6 +53,14 @@ func (c *Consul) InitConfig() error {
 	return nil
 }
 
+// nodeInfo is the optional json value of a node key, the value of the nodes registered by Init is their address
+type nodeInfo struct {
+	Weight   int               `json:"weight"`
+	Zone     string            `json:"zone"`
+	Version  string            `json:"version"`
+	Metadata map[string]string `json:"metadata"`
+}
+
 func (c *Consul) Resolve(serviceName string) ([]*selector.Node, error) {
 
 	pairs, _, err := c.client.KV().List(serviceName, nil)
@@ -64,31 +73,70 @@ func (c *Consul) Resolve(serviceName string) ([]*selector.Node, error) {
 	}
 	var nodes []*selector.Node
 	for _, pair := range pairs {
-		nodes = append(nodes, &selector.Node{
+		node := &selector.Node{
 			Key:   pair.Key,
 			Value: pair.Value,
-		})
+		}
+		node.Address, err = parseAddrFromNode(node)
+		if err != nil {
+			continue
+		}
+
+		info := &nodeInfo{}
+		if len(pair.Value) > 0 && pair.Value[0] == '{' && json.Unmarshal(pair.Value, info) == nil {
+			node.Weight = info.Weight
+			node.Zone = info.Zone
+			node.Version = info.Version
+			node.Metadata = info.Metadata
+		}
+
+		nodes = append(nodes, node)
 	}
 	return nodes, nil
 }
 
-// implements selector Select method
-func (c *Consul) Select(serviceName string) (string, error) {
+// Select implements selector.Selector, the call context is given to the balancer and the outcome
+// of the call is reported to it
+func (c *Consul) Select(ctx context.Context, serviceName string, opts ...selector.Option) (*selector.Node, selector.DoneFunc, error) {
+
+	o := &selector.Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
 
 	nodes, err := c.Resolve(serviceName)
 
 	if nodes == nil || len(nodes) == 0 || err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
-	balancer := selector.GetBalancer(c.balancerName)
-	node := balancer.Balance(serviceName, nodes)
+	nodes = o.Filter(nodes)
+	if len(nodes) == 0 {
+		return nil, nil, fmt.Errorf("no services match the filters in %s", serviceName)
+	}
+
+	// skip the nodes whose circuit breaker is open
+	nodes, err = selector.FilterBroken(serviceName, nodes, nodeAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	balancerName := c.opts.BalancerName
+	if o.BalancerName != "" {
+		balancerName = o.BalancerName
+	}
+	balancer := selector.GetBalancer(balancerName)
+	node, done := selector.BalanceWithDone(ctx, balancer, serviceName, nodes)
 
 	if node == nil {
-		return "", fmt.Errorf("no services find in %s", serviceName)
+		return nil, nil, fmt.Errorf("no services find in %s", serviceName)
 	}
 
-	return parseAddrFromNode(node)
+	return node, done, nil
+}
+
+func nodeAddr(node *selector.Node) (string, error) {
+	return node.Address, nil
 }
 
 func parseAddrFromNode(node *selector.Node) (string, error) {
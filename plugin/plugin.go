@@ -33,6 +33,7 @@ type Options struct {
 	Services        []string // service arrays
 	SelectorSvrAddr string   // server discovery address ，e.g. consul server address
 	TracingSvrAddr  string   // tracing server address，e.g. jaeger server address
+	BalancerName    string   // load balancing mode of the server discovery, e.g. : random、roundRobin、consistentHash
 }
 
 // Option provides operations on Options
@@ -65,3 +66,10 @@ func WithTracingSvrAddr(addr string) Option {
 		o.TracingSvrAddr = addr
 	}
 }
+
+// WithBalancerName allows you to set BalancerName of Options
+func WithBalancerName(name string) Option {
+	return func(o *Options) {
+		o.BalancerName = name
+	}
+}
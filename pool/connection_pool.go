@@ -5,8 +5,11 @@ import (
 	"errors"
 	"io"
 	"net"
+	"reflect"
 	"sync"
 	"time"
+
+	"github.com/HuaTug/My-RPC/auth"
 )
 
 // Pool provides a pooling capability for connections, enabling connection reuse
@@ -14,6 +17,21 @@ type Pool interface {
 	Get(ctx context.Context, network string, address string) (net.Conn, error)
 }
 
+// AuthPool is implemented by the pools which authenticate the connections they dial with a
+// TransportAuth, authority is the target the handshake is done for, e.g. : the TLS server name
+type AuthPool interface {
+	GetAuthenticated(ctx context.Context, network string, address string, authority string, transportAuth auth.TransportAuth) (net.Conn, error)
+}
+
+// poolKey identifies the connections which may be shared. The TransportAuth is part of the key,
+// GetAuthenticated checks it is comparable first
+type poolKey struct {
+	network       string
+	address       string
+	authority     string
+	transportAuth auth.TransportAuth
+}
+
 type pool struct {
 	opts *Options
 	conns *sync.Map //Map newwork address and connection pool instances
@@ -64,21 +82,42 @@ func NewConnPool(opt ...Option) *pool {
 }
 
 func (p *pool) Get(ctx context.Context, network string, address string) (net.Conn, error) {
+	return p.get(ctx, poolKey{network: network, address: address})
+}
+
+// ErrAuthNotComparable is returned for a TransportAuth which cannot key the pool, e.g. : a struct
+// value holding a slice. A pointer to it can
+var ErrAuthNotComparable = errors.New("transport auth is not comparable, use a pointer")
+
+// GetAuthenticated returns a connection whose handshake was done by transportAuth
+func (p *pool) GetAuthenticated(ctx context.Context, network string, address string, authority string, transportAuth auth.TransportAuth) (net.Conn, error) {
+	// a key holding a value which is not comparable makes the map panic
+	if transportAuth != nil && !reflect.ValueOf(transportAuth).Comparable() {
+		return nil, ErrAuthNotComparable
+	}
+	return p.get(ctx, poolKey{network: network, address: address, authority: authority, transportAuth: transportAuth})
+}
+
+func (p *pool) get(ctx context.Context, key poolKey) (net.Conn, error) {
 
 	// get the conn and transfer the channelPool to get conn
-	if value, ok := p.conns.Load(address); ok {
+	if value, ok := p.conns.Load(key); ok {
 		if cp, ok := value.(*channelPool); ok {
 			conn, err := cp.Get(ctx)
 			return conn, err
 		}
 	}
 
-	cp, err := p.NewChannelPool(ctx, network, address)
+	cp, err := p.newChannelPool(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 
-	p.conns.Store(address, cp)
+	// a concurrent call may have created the pool of the key first, keep a single one
+	if value, loaded := p.conns.LoadOrStore(key, cp); loaded {
+		cp.Close()
+		cp = value.(*channelPool)
+	}
 
 	return cp.Get(ctx)
 }
@@ -97,6 +136,11 @@ type channelPool struct {
 
 
 func (p *pool) NewChannelPool(ctx context.Context, network string, address string) (*channelPool, error){
+	return p.newChannelPool(ctx, poolKey{network: network, address: address})
+}
+
+func (p *pool) newChannelPool(ctx context.Context, key poolKey) (*channelPool, error){
+	network, address := key.network, key.address
 	c := &channelPool {
 		initialCap: p.opts.initialCap,
 		maxCap: p.opts.maxCap,
@@ -113,20 +157,35 @@ func (p *pool) NewChannelPool(ctx context.Context, network string, address strin
 				timeout = t.Sub(time.Now())
 			}
 
-			return net.DialTimeout(network, address, timeout)
+			conn, err := net.DialTimeout(network, address, timeout)
+			if err != nil || key.transportAuth == nil {
+				return conn, err
+			}
+
+			// the handshake is bounded by the dial timeout as well
+			conn.SetDeadline(time.Now().Add(timeout))
+			authConn, _, err := key.transportAuth.ClientHandshake(ctx, key.authority, conn)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			authConn.SetDeadline(time.Time{})
+
+			return authConn, nil
 		},
 		conns : make(chan *PoolConn, p.opts.maxCap),
 		idleTimeout: p.opts.idleTimeout,
 		dialTimeout: p.opts.dialTimeout,
 	}
 
-	if p.opts.initialCap == 0 {
-		// default initialCap is 1
-		p.opts.initialCap = 1
+	// default initialCap is 1, the pool options are shared by the concurrent calls and left as is
+	initialCap := p.opts.initialCap
+	if initialCap == 0 {
+		initialCap = 1
 	}
 
 	//	在初始化连池时，需要朝其中填充连接
-	for i := 0; i < p.opts.initialCap; i++ {
+	for i := 0; i < initialCap; i++ {
 		conn , err := c.Dial(ctx);
 		if err != nil {
 			return nil, err
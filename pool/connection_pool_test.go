@@ -0,0 +1,52 @@
+package connpool
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/HuaTug/My-RPC/auth"
+)
+
+// plainAuth does no handshake, the slice makes its values not comparable
+type plainAuth struct {
+	names []string
+}
+
+func (a plainAuth) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, auth.AuthInfo, error) {
+	return conn, nil, nil
+}
+
+func (a plainAuth) ServerHandshake(conn net.Conn) (net.Conn, auth.AuthInfo, error) {
+	return conn, nil, nil
+}
+
+func TestGetAuthenticatedNotComparable(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	p := NewConnPool()
+	addr := lis.Addr().String()
+
+	if _, err := p.GetAuthenticated(context.Background(), "tcp", addr, addr, plainAuth{}); err != ErrAuthNotComparable {
+		t.Fatalf("GetAuthenticated() with a struct value error = %v, want %v", err, ErrAuthNotComparable)
+	}
+
+	conn, err := p.GetAuthenticated(context.Background(), "tcp", addr, addr, &plainAuth{})
+	if err != nil {
+		t.Fatalf("GetAuthenticated() with a pointer error = %v", err)
+	}
+	conn.Close()
+}
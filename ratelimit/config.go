@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Rule defines a token bucket limit. Empty Service or Method fields match every service or method
+type Rule struct {
+	Service   string  `json:"service"`    // service name, e.g. : test.Greeter
+	Method    string  `json:"method"`     // method name, e.g. : SayHello
+	PerCaller bool    `json:"per_caller"` // one bucket per caller identity instead of one shared bucket
+	Rate      float64 `json:"rate"`       // tokens refilled per second
+	Burst     int     `json:"burst"`      // bucket capacity
+}
+
+// Config declares the limits applied by a Limiter. A request must pass every rule it matches
+type Config struct {
+	// metadata keys identifying the caller, the first present one is used. The metadata is set by the
+	// callers themselves, so they are only trusted when set, e.g. : behind a proxy adding them. The
+	// caller is otherwise the authenticated peer identity, or the peer address
+	CallerKeys []string `json:"caller_keys"`
+	Rules      []Rule   `json:"rules"`
+}
+
+// LoadConfig reads a Config from a json file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// validate rejects the rules which would deny every request
+func (c *Config) validate() error {
+	for i, r := range c.Rules {
+		if r.Burst < 1 {
+			return fmt.Errorf("rate limit rule %d: burst %d denies every request", i, r.Burst)
+		}
+		if r.Rate < 0 {
+			return fmt.Errorf("rate limit rule %d: negative rate %v", i, r.Rate)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) match(serviceName, method string) bool {
+	return (r.Service == "" || r.Service == serviceName) && (r.Method == "" || r.Method == method)
+}
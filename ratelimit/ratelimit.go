@@ -0,0 +1,230 @@
+// Package ratelimit applies token bucket limits per service, per method and per caller on the server side
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HuaTug/My-RPC/auth"
+	"github.com/HuaTug/My-RPC/codes"
+	"github.com/HuaTug/My-RPC/interceptor"
+	"github.com/HuaTug/My-RPC/metadata"
+	"github.com/HuaTug/My-RPC/peer"
+	"github.com/HuaTug/My-RPC/stream"
+)
+
+// RetryAfterKey is the response metadata key carrying how many milliseconds a
+// rejected caller should wait before retrying
+const RetryAfterKey = "retry-after-ms"
+
+// sweepInterval is the interval at which idle per-caller buckets are dropped
+const sweepInterval = time.Minute
+
+// Limiter holds the token buckets of a Config
+type Limiter struct {
+	cfg *Config
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	rule   int    // index of the rule in Config.Rules
+	caller string // caller identity, empty for shared buckets
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time // last refill time
+}
+
+// BucketState describes a token bucket, for debugging
+type BucketState struct {
+	Service string  `json:"service"`
+	Method  string  `json:"method"`
+	Caller  string  `json:"caller"`
+	Tokens  float64 `json:"tokens"`
+	Rate    float64 `json:"rate"`
+	Burst   int     `json:"burst"`
+}
+
+// New creates a Limiter from a Config, it fails when a rule has no burst
+func New(cfg *Config) (*Limiter, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return &Limiter{
+		cfg:       cfg,
+		buckets:   make(map[bucketKey]*bucket),
+		lastSweep: time.Now(),
+	}, nil
+}
+
+// Allow takes a token from every bucket matching the request. When one of them is empty no token
+// is taken and the time to wait until the request would be allowed is returned
+func (l *Limiter) Allow(serviceName, method, caller string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	var matched []*bucket
+	var denied bool
+	var retryAfter time.Duration
+
+	for i := range l.cfg.Rules {
+		rule := &l.cfg.Rules[i]
+		if !rule.match(serviceName, method) {
+			continue
+		}
+
+		key := bucketKey{rule: i}
+		if rule.PerCaller {
+			key.caller = caller
+		}
+
+		b, ok := l.buckets[key]
+		if !ok {
+			b = &bucket{
+				tokens: float64(rule.Burst),
+				last:   now,
+			}
+			l.buckets[key] = b
+		}
+		b.refill(now, rule)
+
+		if b.tokens < 1 {
+			denied = true
+			wait := time.Second
+			if rule.Rate > 0 {
+				wait = time.Duration((1 - b.tokens) / rule.Rate * float64(time.Second))
+			}
+			if wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+		matched = append(matched, b)
+	}
+
+	if denied {
+		return false, retryAfter
+	}
+
+	for _, b := range matched {
+		b.tokens--
+	}
+
+	return true, 0
+}
+
+// State returns the current state of all the buckets
+func (l *Limiter) State() []BucketState {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	states := make([]BucketState, 0, len(l.buckets))
+	for key, b := range l.buckets {
+		rule := &l.cfg.Rules[key.rule]
+		b.refill(now, rule)
+		states = append(states, BucketState{
+			Service: rule.Service,
+			Method:  rule.Method,
+			Caller:  key.caller,
+			Tokens:  b.tokens,
+			Rate:    rule.Rate,
+			Burst:   rule.Burst,
+		})
+	}
+
+	return states
+}
+
+// sweep drops the buckets which are full again, they are recreated full when needed
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		rule := &l.cfg.Rules[key.rule]
+		b.refill(now, rule)
+		if b.tokens >= float64(rule.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (b *bucket) refill(now time.Time, rule *Rule) {
+	b.tokens += now.Sub(b.last).Seconds() * rule.Rate
+	if b.tokens > float64(rule.Burst) {
+		b.tokens = float64(rule.Burst)
+	}
+	b.last = now
+}
+
+// caller returns the identity of the caller : from the trusted metadata keys, else the authenticated
+// peer identity, else the peer host. Credentials are hashed so that they never show up in the limiter state
+func (l *Limiter) caller(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, key := range l.cfg.CallerKeys {
+		values := md.Get(key)
+		if len(values) == 0 || values[0] == "" {
+			continue
+		}
+		if strings.EqualFold(key, "authorization") {
+			sum := sha256.Sum256([]byte(values[0]))
+			return "sha256:" + hex.EncodeToString(sum[:8])
+		}
+		return values[0]
+	}
+
+	if id, ok := auth.IdentityFromContext(ctx); ok {
+		return "id:" + id.String()
+	}
+
+	// the port changes with every connection of the caller
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr := p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		return "addr:" + addr
+	}
+	return ""
+}
+
+// ServerInterceptor builds a server interceptor which rejects the requests exceeding the limits
+// with codes.ResourceExhaustedError, a RetryAfterKey hint in the response metadata and a codes.RetryInfo detail
+func ServerInterceptor(l *Limiter) interceptor.ServerInterceptor {
+
+	return func(ctx context.Context, req interface{}, handler interceptor.Handler) (interface{}, error) {
+		var serviceName, method string
+		if ss, ok := ctx.Value(stream.ServerStreamKey).(*stream.ServerStream); ok {
+			serviceName, method = ss.ServiceName, ss.Method
+		}
+
+		caller := l.caller(ctx)
+
+		if ok, retryAfter := l.Allow(serviceName, method, caller); !ok {
+			ms := (retryAfter + time.Millisecond - 1) / time.Millisecond
+			return nil, codes.ResourceExhaustedError.
+				WithMetadata(RetryAfterKey, []byte(strconv.FormatInt(int64(ms), 10))).
+				WithDetails(&codes.RetryInfo{RetryDelay: ms * time.Millisecond})
+		}
+
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,201 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codes"
+	"github.com/HuaTug/My-RPC/metadata"
+	"github.com/HuaTug/My-RPC/peer"
+	"github.com/HuaTug/My-RPC/stream"
+)
+
+func TestNewRejectsEmptyBurst(t *testing.T) {
+	if _, err := New(&Config{Rules: []Rule{{Rate: 10, Burst: 0}}}); err == nil {
+		t.Error("New() accepted a rule with burst 0")
+	}
+	if _, err := New(&Config{Rules: []Rule{{Rate: 10, Burst: 1}}}); err != nil {
+		t.Errorf("New() error = %v", err)
+	}
+}
+
+// incoming returns the context of a request received from addr with a caller-id header
+func incoming(addr, callerID string) context.Context {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 40000 + len(callerID)},
+	})
+	return metadata.NewIncomingContext(ctx, metadata.MD{"caller-id": []string{callerID}})
+}
+
+func TestCallerDefaultsToPeer(t *testing.T) {
+	l, err := New(&Config{Rules: []Rule{{PerCaller: true, Burst: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new caller-id header from the same host does not get a new bucket
+	if got, want := l.caller(incoming("10.0.0.1", "a")), l.caller(incoming("10.0.0.1", "spoofed")); got != want {
+		t.Errorf("caller = %q and %q, want the same peer host", got, want)
+	}
+	if l.caller(incoming("10.0.0.1", "a")) == l.caller(incoming("10.0.0.2", "a")) {
+		t.Error("two hosts share a caller")
+	}
+}
+
+func TestCallerFromTrustedKeys(t *testing.T) {
+	l, err := New(&Config{CallerKeys: []string{"caller-id"}, Rules: []Rule{{PerCaller: true, Burst: 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := l.caller(incoming("10.0.0.1", "a")); got != "a" {
+		t.Errorf("caller = %q, want the caller-id header", got)
+	}
+}
+
+func mustNew(t *testing.T, cfg *Config) *Limiter {
+	l, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+// elapse moves the buckets of l back in time, as if d had passed since they were last refilled
+func elapse(l *Limiter, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, b := range l.buckets {
+		b.last = b.last.Add(-d)
+	}
+}
+
+// allowed returns how many requests are allowed in a row
+func allowed(l *Limiter, service, method, caller string) int {
+	n := 0
+	for ; n < 100; n++ {
+		if ok, _ := l.Allow(service, method, caller); !ok {
+			break
+		}
+	}
+	return n
+}
+
+func TestAllowDrainsBurst(t *testing.T) {
+	l := mustNew(t, &Config{Rules: []Rule{{Rate: 1, Burst: 3}}})
+
+	if n := allowed(l, "test.Greeter", "SayHello", ""); n != 3 {
+		t.Errorf("%d requests allowed, want the burst of 3", n)
+	}
+}
+
+func TestRefill(t *testing.T) {
+	l := mustNew(t, &Config{Rules: []Rule{{Rate: 10, Burst: 2}}})
+	allowed(l, "test.Greeter", "SayHello", "")
+
+	// a token is refilled every 100ms
+	elapse(l, 100*time.Millisecond)
+	if n := allowed(l, "test.Greeter", "SayHello", ""); n != 1 {
+		t.Errorf("%d requests allowed after 100ms, want 1", n)
+	}
+
+	// the bucket never holds more than the burst
+	elapse(l, time.Hour)
+	if n := allowed(l, "test.Greeter", "SayHello", ""); n != 2 {
+		t.Errorf("%d requests allowed after an hour, want the burst of 2", n)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	l := mustNew(t, &Config{Rules: []Rule{{Rate: 4, Burst: 1}}})
+	allowed(l, "test.Greeter", "SayHello", "")
+
+	// within a few ms of the 250ms needed for the next token
+	ok, retryAfter := l.Allow("test.Greeter", "SayHello", "")
+	if ok || retryAfter > 250*time.Millisecond || retryAfter < 240*time.Millisecond {
+		t.Errorf("Allow() = %v, %v, want false, 250ms", ok, retryAfter)
+	}
+
+	elapse(l, 125*time.Millisecond)
+	ok, retryAfter = l.Allow("test.Greeter", "SayHello", "")
+	if ok || retryAfter > 125*time.Millisecond || retryAfter < 115*time.Millisecond {
+		t.Errorf("Allow() after 125ms = %v, %v, want false, 125ms", ok, retryAfter)
+	}
+
+	// a bucket which never refills is retried after a second
+	l = mustNew(t, &Config{Rules: []Rule{{Burst: 1}}})
+	allowed(l, "test.Greeter", "SayHello", "")
+	if ok, retryAfter := l.Allow("test.Greeter", "SayHello", ""); ok || retryAfter != time.Second {
+		t.Errorf("Allow() without refill = %v, %v, want false, 1s", ok, retryAfter)
+	}
+}
+
+func TestPerMethodAndPerCallerRules(t *testing.T) {
+	l := mustNew(t, &Config{Rules: []Rule{
+		{Service: "test.Greeter", Method: "SayHello", Burst: 1}, // shared by the callers of SayHello
+		{PerCaller: true, Burst: 2},                             // per caller, for every method
+	}})
+
+	tests := []struct {
+		method string
+		caller string
+		want   bool
+	}{
+		{"SayHello", "a", true},
+		{"SayHello", "b", false}, // the SayHello bucket is shared
+		{"SayBye", "a", true},
+		{"SayBye", "a", false}, // a used its 2 tokens
+		{"SayBye", "b", true},  // the denied SayHello took no token of b
+		{"SayBye", "b", true},
+		{"SayBye", "b", false},
+	}
+	for i, tt := range tests {
+		if ok, _ := l.Allow("test.Greeter", tt.method, tt.caller); ok != tt.want {
+			t.Errorf("request %d, %s of %s: Allow() = %v, want %v", i, tt.method, tt.caller, ok, tt.want)
+		}
+	}
+
+	// the rules of a method do not apply to the other services
+	if n := allowed(l, "test.Other", "SayHello", "c"); n != 2 {
+		t.Errorf("%d requests of another service allowed, want the per caller burst of 2", n)
+	}
+}
+
+func TestServerInterceptorRejects(t *testing.T) {
+	intercept := ServerInterceptor(mustNew(t, &Config{Rules: []Rule{{Rate: 2, Burst: 1}}}))
+
+	ctx, ss := stream.NewServerStream(incoming("10.0.0.1", ""))
+	ss.WithServiceName("test.Greeter").WithMethod("SayHello")
+
+	called := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		called++
+		return nil, nil
+	}
+	if _, err := intercept(ctx, nil, handler); err != nil {
+		t.Fatalf("intercept() = %v, want the request allowed", err)
+	}
+
+	_, err := intercept(ctx, nil, handler)
+	if codes.CodeOf(err) != codes.ResourceExhausted || called != 1 {
+		t.Fatalf("intercept() = %v, handler called %d times, want code %v", err, called, codes.ResourceExhausted)
+	}
+
+	e := err.(*codes.Error)
+	ms, perr := strconv.Atoi(string(e.Metadata[RetryAfterKey]))
+	if perr != nil || ms < 490 || ms > 500 {
+		t.Errorf("%s = %q, want about 500", RetryAfterKey, e.Metadata[RetryAfterKey])
+	}
+	var retry *codes.RetryInfo
+	for _, d := range e.Details {
+		if r, ok := d.(*codes.RetryInfo); ok {
+			retry = r
+		}
+	}
+	if retry == nil || retry.RetryDelay != time.Duration(ms)*time.Millisecond {
+		t.Errorf("retry info = %v, want a delay of %dms", retry, ms)
+	}
+}
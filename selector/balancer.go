@@ -17,6 +17,7 @@ const (
 	RoundRobin         = "roundRobin"
 	WeightedRoundRobin = "weightedRoundRobin"
 	ConsistentHash     = "consistentHash"
+	P2C                = "p2c"
 
 	Custom = "custom"
 )
@@ -25,6 +26,8 @@ func init() {
 	RegisterBalancer(Random, DefaultBalancer)
 	RegisterBalancer(RoundRobin, RRBalancer)
 	RegisterBalancer(WeightedRoundRobin, WRRBalancer)
+	RegisterBalancer(ConsistentHash, CHBalancer)
+	RegisterBalancer(P2C, P2CBalancer)
 }
 
 // RandomBalancer is adopted as the default load balancer
@@ -36,6 +39,12 @@ var RRBalancer = newRoundRobinBalancer()
 // A unique WeightedRoundRobinBalancer instance is used globally
 var WRRBalancer = newWeightedRoundRobinBalancer()
 
+// A unique ConsistentHashBalancer instance is used globally
+var CHBalancer = NewConsistentHashBalancer(defaultVirtualNodes)
+
+// A unique P2CBalancer instance is used globally, it learns the node latencies from all the calls
+var P2CBalancer = newP2CBalancer()
+
 // RegisterBalancer supports business custom registered Balancer
 func RegisterBalancer(name string, balancer Balancer) {
 	if balancerMap == nil {
@@ -0,0 +1,29 @@
+package selector
+
+import (
+	"github.com/HuaTug/My-RPC/breaker"
+	"github.com/HuaTug/My-RPC/codes"
+)
+
+// FilterBroken drops the nodes whose circuit breaker is open, so that they are skipped by the Balancer.
+// It returns codes.CircuitBreakerOpenError when all the nodes of the service are open
+func FilterBroken(serviceName string, nodes []*Node, addrOf func(*Node) (string, error)) ([]*Node, error) {
+	group := breaker.Get(serviceName)
+	if group == nil || len(nodes) == 0 {
+		return nodes, nil
+	}
+
+	ready := make([]*Node, 0, len(nodes))
+	for _, node := range nodes {
+		addr, err := addrOf(node)
+		if err != nil || group.Ready(addr) {
+			ready = append(ready, node)
+		}
+	}
+
+	if len(ready) == 0 {
+		return nil, codes.CircuitBreakerOpenError
+	}
+
+	return ready, nil
+}
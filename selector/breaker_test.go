@@ -0,0 +1,38 @@
+package selector
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/HuaTug/My-RPC/breaker"
+	"github.com/HuaTug/My-RPC/codes"
+)
+
+func TestFilterBrokenAllOpenIsDistinguishable(t *testing.T) {
+	group := breaker.NewGroup(breaker.WithConsecutiveFailures(1))
+	breaker.Register("test.AllOpen", group)
+
+	nodes := []*Node{{Address: "127.0.0.1:8000"}, {Address: "127.0.0.1:8001"}}
+	for _, node := range nodes {
+		group.Allow(node.Address)
+		group.Report(node.Address, errors.New("connection reset"))
+	}
+
+	_, err := FilterBroken("test.AllOpen", nodes, func(n *Node) (string, error) { return n.Address, nil })
+	if err == nil {
+		t.Fatal("FilterBroken() kept open nodes")
+	}
+	if !codes.HasReason(err, codes.ReasonCircuitOpen) {
+		t.Errorf("error %v does not have the reason %s", err, codes.ReasonCircuitOpen)
+	}
+
+	// the errors sharing its code have other reasons
+	for _, other := range []*codes.Error{codes.ServerOverloadError, codes.New(codes.Unavailable, "down")} {
+		if codes.HasReason(other, codes.ReasonCircuitOpen) {
+			t.Errorf("%v has the reason %s", other, codes.ReasonCircuitOpen)
+		}
+	}
+	if codes.HasReason(err, codes.ReasonServerOverloaded) || codes.HasReason(err, codes.ReasonNodeCircuitOpen) {
+		t.Errorf("error %v has another reason too", err)
+	}
+}
@@ -0,0 +1,118 @@
+package selector
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultVirtualNodes is how many points of the ring a node of weight 1 owns
+const defaultVirtualNodes = 160
+
+// consistentHashBalancer places virtual nodes on a hash ring, a call goes to the node owning the
+// first point after the hash of its key. Adding or removing a node only remaps the keys of its points
+type consistentHashBalancer struct {
+	virtualNodes int
+	rings        *sync.Map // service name -> *hashRing
+}
+
+// NewConsistentHashBalancer returns a ring hash Balancer placing virtualNodes points per node,
+// multiplied by the node weight when set. The calls without a hash key go to a random node
+func NewConsistentHashBalancer(virtualNodes int) Balancer {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+	return &consistentHashBalancer{
+		virtualNodes: virtualNodes,
+		rings:        new(sync.Map),
+	}
+}
+
+type ringPoint struct {
+	hash uint64
+	node *Node
+}
+
+type hashRing struct {
+	signature string      // node keys the ring was built from
+	points    []ringPoint // sorted by hash
+}
+
+// Balance has no hash key, it picks a random node
+func (c *consistentHashBalancer) Balance(serviceName string, nodes []*Node) *Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[rand.Intn(len(nodes))]
+}
+
+func (c *consistentHashBalancer) BalanceContext(ctx context.Context, serviceName string, nodes []*Node) *Node {
+	key, ok := HashKeyFromContext(ctx)
+	if !ok || len(nodes) == 0 {
+		return c.Balance(serviceName, nodes)
+	}
+
+	ring := c.ring(serviceName, nodes)
+	h := hashString(key)
+	i := sort.Search(len(ring.points), func(i int) bool {
+		return ring.points[i].hash >= h
+	})
+	if i == len(ring.points) {
+		i = 0
+	}
+	return ring.points[i].node
+}
+
+// ring returns the ring of the nodes, it is rebuilt when the nodes of the service change
+func (c *consistentHashBalancer) ring(serviceName string, nodes []*Node) *hashRing {
+	signature := nodesSignature(nodes)
+	if r, ok := c.rings.Load(serviceName); ok && r.(*hashRing).signature == signature {
+		return r.(*hashRing)
+	}
+
+	ring := &hashRing{signature: signature}
+	for _, node := range nodes {
+		n := c.virtualNodes
+		if node.Weight > 1 {
+			n *= node.Weight
+		}
+		for i := 0; i < n; i++ {
+			ring.points = append(ring.points, ringPoint{
+				hash: hashString(node.id() + "#" + strconv.Itoa(i)),
+				node: node,
+			})
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool {
+		return ring.points[i].hash < ring.points[j].hash
+	})
+
+	c.rings.Store(serviceName, ring)
+	return ring
+}
+
+func nodesSignature(nodes []*Node) string {
+	keys := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		keys = append(keys, node.id()+"/"+strconv.Itoa(node.Weight))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\n")
+}
+
+// hashString is fnv-1a followed by the murmur3 finalizer, which spreads the close keys over the ring
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
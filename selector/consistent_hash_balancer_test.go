@@ -0,0 +1,111 @@
+package selector
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/HuaTug/My-RPC/metadata"
+)
+
+const testKeys = 100000
+
+func testNodes(n int) []*Node {
+	nodes := make([]*Node, 0, n)
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, &Node{Key: fmt.Sprintf("test.Greeter/10.0.0.%d:8000", i), Address: fmt.Sprintf("10.0.0.%d:8000", i)})
+	}
+	return nodes
+}
+
+// assign returns the node key of every hash key
+func assign(b Balancer, serviceName string, nodes []*Node) map[string]string {
+	cb := b.(ContextBalancer)
+	owners := make(map[string]string, testKeys)
+	for i := 0; i < testKeys; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		owners[key] = cb.BalanceContext(WithHashKey(context.Background(), key), serviceName, nodes).Key
+	}
+	return owners
+}
+
+func TestConsistentHashDistribution(t *testing.T) {
+	nodes := testNodes(10)
+	owners := assign(NewConsistentHashBalancer(0), "distribution", nodes)
+
+	counts := make(map[string]int)
+	for _, owner := range owners {
+		counts[owner]++
+	}
+	if len(counts) != len(nodes) {
+		t.Fatalf("keys spread over %d nodes, want %d", len(counts), len(nodes))
+	}
+
+	ideal := float64(testKeys) / float64(len(nodes))
+	for node, count := range counts {
+		if math.Abs(float64(count)-ideal)/ideal > 0.25 {
+			t.Errorf("node %s got %d keys, want %.0f ± 25%%", node, count, ideal)
+		}
+	}
+}
+
+func TestConsistentHashStability(t *testing.T) {
+	b := NewConsistentHashBalancer(0)
+	nodes := testNodes(10)
+	before := assign(b, "stability", nodes)
+
+	// the same key goes to the same node as long as the nodes do not change
+	if again := assign(b, "stability", nodes); fmt.Sprint(again) != fmt.Sprint(before) {
+		t.Fatal("keys remapped while the nodes did not change")
+	}
+
+	removed := nodes[3]
+	t.Run("remove", func(t *testing.T) {
+		after := assign(b, "stability", append(append([]*Node{}, nodes[:3]...), nodes[4:]...))
+		checkRemap(t, before, after, 1.0/10, func(was, now string) bool { return was == removed.Key })
+	})
+
+	added := &Node{Key: "test.Greeter/10.0.0.99:8000"}
+	t.Run("add", func(t *testing.T) {
+		after := assign(b, "stability", append(testNodes(10), added))
+		checkRemap(t, before, after, 1.0/11, func(was, now string) bool { return now == added.Key })
+	})
+}
+
+// checkRemap checks that about the expected share of the keys moved, and only the keys expected to move
+func checkRemap(t *testing.T, before, after map[string]string, share float64, expected func(was, now string) bool) {
+	moved := 0
+	for key, was := range before {
+		now := after[key]
+		if was == now {
+			continue
+		}
+		moved++
+		if !expected(was, now) {
+			t.Fatalf("key %s moved from %s to %s", key, was, now)
+		}
+	}
+
+	if got := float64(moved) / testKeys; math.Abs(got-share) > share/3 {
+		t.Errorf("%.3f of the keys moved, want about %.3f", got, share)
+	}
+}
+
+func TestConsistentHashKeyFromMetadataAndFallback(t *testing.T) {
+	b := NewConsistentHashBalancer(0).(ContextBalancer)
+	nodes := testNodes(5)
+
+	mdCtx := metadata.AppendToOutgoingContext(context.Background(), HashKeyMetadataKey, "alice")
+	want := b.BalanceContext(WithHashKey(context.Background(), "alice"), "fallback", nodes)
+	if got := b.BalanceContext(mdCtx, "fallback", nodes); got != want {
+		t.Errorf("node of the metadata hash key = %s, want %s", got.Key, want.Key)
+	}
+
+	if node := b.BalanceContext(context.Background(), "fallback", nodes); node == nil {
+		t.Fatal("BalanceContext() without hash key returned no node")
+	}
+	if node := b.BalanceContext(context.Background(), "fallback", nil); node != nil {
+		t.Fatalf("BalanceContext() without nodes = %v, want nil", node)
+	}
+}
@@ -0,0 +1,56 @@
+package selector
+
+import (
+	"context"
+	"sync"
+)
+
+type exclusionKey struct{}
+
+// Exclusion records the addresses already picked for one logical call, so that
+// parallel attempts of the same call (e.g. hedged requests) land on distinct nodes
+type Exclusion struct {
+	mu    sync.Mutex
+	addrs map[string]struct{}
+}
+
+// NewExclusion creates an empty Exclusion
+func NewExclusion() *Exclusion {
+	return &Exclusion{
+		addrs: make(map[string]struct{}),
+	}
+}
+
+// Claim marks addr as used and reports whether no other attempt has used it before
+func (e *Exclusion) Claim(addr string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.addrs[addr]; ok {
+		return false
+	}
+	e.addrs[addr] = struct{}{}
+	return true
+}
+
+// Contains reports whether addr has already been claimed
+func (e *Exclusion) Contains(addr string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.addrs[addr]
+	return ok
+}
+
+// WithExclusion returns a new context carrying the Exclusion
+func WithExclusion(ctx context.Context, e *Exclusion) context.Context {
+	return context.WithValue(ctx, exclusionKey{}, e)
+}
+
+// ExclusionFromContext returns the Exclusion attached to the context, or nil
+func ExclusionFromContext(ctx context.Context) *Exclusion {
+	if e, ok := ctx.Value(exclusionKey{}).(*Exclusion); ok {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package selector
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DoneFunc reports the outcome of the call sent to a picked node, it is called once per pick
+type DoneFunc func(err error, latency time.Duration)
+
+// ErrNotUsed is given to the DoneFunc of a node which was picked but not sent the call,
+// e.g. : a node already used by another attempt of a hedged call
+var ErrNotUsed = errors.New("node picked but not used")
+
+// FeedbackBalancer is implemented by the Balancers learning from the outcome of the calls,
+// BalanceWithDone is called instead of Balance and BalanceContext
+type FeedbackBalancer interface {
+	BalanceWithDone(ctx context.Context, serviceName string, nodes []*Node) (*Node, DoneFunc)
+}
+
+// BalanceWithDone picks a node with the balancer, the DoneFunc is nil unless the balancer is a FeedbackBalancer
+func BalanceWithDone(ctx context.Context, balancer Balancer, serviceName string, nodes []*Node) (*Node, DoneFunc) {
+	if fb, ok := balancer.(FeedbackBalancer); ok {
+		return fb.BalanceWithDone(ctx, serviceName, nodes)
+	}
+	return BalanceContext(ctx, balancer, serviceName, nodes), nil
+}
@@ -0,0 +1,46 @@
+package selector
+
+import (
+	"context"
+
+	"github.com/HuaTug/My-RPC/metadata"
+)
+
+// HashKeyMetadataKey is the outgoing metadata key whose value is hashed by the ConsistentHash
+// balancer when the call context carries no hash key
+const HashKeyMetadataKey = "x-hash-key"
+
+type hashKey struct{}
+
+// WithHashKey returns a new context carrying the key the ConsistentHash balancer hashes the call on,
+// the calls of a same key are sent to a same node as long as the nodes do not change
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// HashKeyFromContext returns the hash key of a call : the one set by WithHashKey, or else the
+// value of HashKeyMetadataKey in the outgoing metadata
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	if key, ok := ctx.Value(hashKey{}).(string); ok {
+		return key, true
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if values := md.Get(HashKeyMetadataKey); len(values) > 0 {
+			return values[0], true
+		}
+	}
+	return "", false
+}
+
+// ContextBalancer is implemented by the Balancers which pick the node from the call, e.g. : from its hash key
+type ContextBalancer interface {
+	BalanceContext(ctx context.Context, serviceName string, nodes []*Node) *Node
+}
+
+// BalanceContext picks a node with the balancer, the context is given to the ContextBalancers
+func BalanceContext(ctx context.Context, balancer Balancer, serviceName string, nodes []*Node) *Node {
+	if cb, ok := balancer.(ContextBalancer); ok {
+		return cb.BalanceContext(ctx, serviceName, nodes)
+	}
+	return balancer.Balance(serviceName, nodes)
+}
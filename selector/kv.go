@@ -2,7 +2,19 @@ package selector
 
 // Node defines the basic information for a service Node
 type Node struct {
-	Key    string
-	Value  []byte
-	weight int
+	Key      string            // key of the node in the registry
+	Value    []byte            // value of the node in the registry
+	Address  string            // address the calls are sent to, e.g. : 127.0.0.1:8000
+	Weight   int               // share of the calls for the weighted balancers, 0 and 1 are the default share
+	Zone     string            // zone the node runs in, e.g. : us-east-1a
+	Version  string            // version of the service served by the node
+	Metadata map[string]string // any other attribute of the node
+}
+
+// id identifies the node within its service for the balancers, nodes without key are identified by their address
+func (n *Node) id() string {
+	if n.Key != "" {
+		return n.Key
+	}
+	return n.Address
 }
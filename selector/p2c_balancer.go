@@ -0,0 +1,191 @@
+package selector
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codes"
+)
+
+const (
+	// ewmaDecay is the time constant of the latency average, older samples weigh e^-1 after it
+	ewmaDecay = 10 * time.Second
+
+	// failureLatency is the latency a failed call counts as at least, so that failing fast does not attract traffic
+	failureLatency = time.Second
+
+	// unmeasuredCost is the cost of a node without latency sample once it has a call in flight,
+	// so that a new node is probed with one call at a time
+	unmeasuredCost = float64(math.MaxInt32)
+
+	// forcePickInterval is how long a node may lose the comparisons before it is picked anyway,
+	// its latency would otherwise never be measured again
+	forcePickInterval = time.Second
+)
+
+// p2cBalancer picks the less loaded of two random nodes, the load of a node being its average
+// latency multiplied by its calls in flight. Slow or overloaded nodes receive less traffic
+type p2cBalancer struct {
+	services *sync.Map // service name -> *p2cService
+}
+
+// p2cService holds the stats of the nodes of a service, the stats of the nodes which left are dropped
+type p2cService struct {
+	signature atomic.Value // string, node keys the stats were pruned for
+	mu        sync.Mutex
+	nodes     *sync.Map // node key -> *nodeStats
+}
+
+func newP2CBalancer() *p2cBalancer {
+	return &p2cBalancer{
+		services: new(sync.Map),
+	}
+}
+
+// nodeStats tracks the calls of a node
+type nodeStats struct {
+	inflight int64 // calls in flight
+	picked   int64 // unix nano of the last pick
+
+	mu      sync.Mutex
+	ewma    float64 // average latency in nanoseconds, 0 before the first sample
+	updated time.Time
+}
+
+func (n *nodeStats) cost() float64 {
+	inflight := atomic.LoadInt64(&n.inflight)
+
+	n.mu.Lock()
+	ewma := n.ewma
+	n.mu.Unlock()
+
+	if ewma == 0 {
+		if inflight > 0 {
+			return unmeasuredCost
+		}
+		return 0
+	}
+	return ewma * float64(inflight+1)
+}
+
+func (n *nodeStats) observe(latency time.Duration) {
+	now := time.Now()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.ewma == 0 {
+		n.ewma = float64(latency)
+	} else {
+		w := math.Exp(-float64(now.Sub(n.updated)) / float64(ewmaDecay))
+		n.ewma = n.ewma*w + float64(latency)*(1-w)
+	}
+	n.updated = now
+}
+
+// service returns the stats of the nodes of a service. When the nodes change, the stats of the nodes
+// missing from them are dropped, a node filtered out of a call is measured again once it is back
+func (p *p2cBalancer) service(serviceName string, nodes []*Node) *p2cService {
+	v, ok := p.services.Load(serviceName)
+	if !ok {
+		v, _ = p.services.LoadOrStore(serviceName, &p2cService{nodes: new(sync.Map)})
+	}
+	svc := v.(*p2cService)
+
+	signature := nodesSignature(nodes)
+	if s, _ := svc.signature.Load().(string); s == signature {
+		return svc
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	if s, _ := svc.signature.Load().(string); s == signature {
+		return svc
+	}
+
+	present := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		present[node.id()] = true
+	}
+	// the calls in flight on a dropped node keep their stats until they are done
+	svc.nodes.Range(func(key, _ interface{}) bool {
+		if !present[key.(string)] {
+			svc.nodes.Delete(key)
+		}
+		return true
+	})
+	svc.signature.Store(signature)
+
+	return svc
+}
+
+func (s *p2cService) stats(node *Node) *nodeStats {
+	v, _ := s.nodes.LoadOrStore(node.id(), &nodeStats{})
+	return v.(*nodeStats)
+}
+
+// pick returns the node of the lower cost among two random nodes
+func (p *p2cBalancer) pick(serviceName string, nodes []*Node) (*Node, *nodeStats) {
+	svc := p.service(serviceName, nodes)
+	if len(nodes) == 1 {
+		return nodes[0], svc.stats(nodes[0])
+	}
+
+	i := rand.Intn(len(nodes))
+	j := rand.Intn(len(nodes) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := nodes[i], nodes[j]
+	sa, sb := svc.stats(a), svc.stats(b)
+
+	if sa.cost() > sb.cost() {
+		a, b = b, a
+		sa, sb = sb, sa
+	}
+
+	now := time.Now().UnixNano()
+	if now-atomic.LoadInt64(&sb.picked) > int64(forcePickInterval) {
+		a, sa = b, sb
+	}
+	atomic.StoreInt64(&sa.picked, now)
+
+	return a, sa
+}
+
+// Balance picks a node without learning the outcome of the call
+func (p *p2cBalancer) Balance(serviceName string, nodes []*Node) *Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+	node, _ := p.pick(serviceName, nodes)
+	return node
+}
+
+func (p *p2cBalancer) BalanceWithDone(ctx context.Context, serviceName string, nodes []*Node) (*Node, DoneFunc) {
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+
+	node, stats := p.pick(serviceName, nodes)
+	atomic.AddInt64(&stats.inflight, 1)
+
+	var once sync.Once
+	return node, func(err error, latency time.Duration) {
+		once.Do(func() {
+			atomic.AddInt64(&stats.inflight, -1)
+			if err == ErrNotUsed {
+				return
+			}
+			// the errors answered for the request, e.g. : NotFound, are not failures of the node
+			if codes.IsNodeFailure(err) && latency < failureLatency {
+				latency = failureLatency
+			}
+			stats.observe(latency)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package selector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codes"
+)
+
+func TestP2CCountsNodeFailuresOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		latency time.Duration
+	}{
+		{"success", nil, time.Millisecond},
+		{"business error", codes.New(codes.NotFound, "no such user"), time.Millisecond},
+		{"unavailable", codes.New(codes.Unavailable, "overloaded"), failureLatency},
+		{"transport error", context.DeadlineExceeded, failureLatency},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newP2CBalancer()
+			node := &Node{Address: "127.0.0.1:8000"}
+
+			_, done := p.BalanceWithDone(context.Background(), "svc", []*Node{node})
+			done(tt.err, time.Millisecond)
+
+			stats := p.service("svc", []*Node{node}).stats(node)
+			if got := time.Duration(stats.ewma); got != tt.latency {
+				t.Errorf("latency = %v, want %v", got, tt.latency)
+			}
+		})
+	}
+}
+
+func TestP2CDropsStatsOfRemovedNodes(t *testing.T) {
+	p := newP2CBalancer()
+	a := &Node{Address: "127.0.0.1:8000"}
+	b := &Node{Address: "127.0.0.1:8001"}
+	c := &Node{Address: "127.0.0.1:8002"}
+
+	for _, nodes := range [][]*Node{{a, b, c}, {a, b}} {
+		for i := 0; i < 100; i++ {
+			_, done := p.BalanceWithDone(context.Background(), "svc", nodes)
+			done(nil, time.Millisecond)
+		}
+	}
+
+	v, _ := p.services.Load("svc")
+	var keys []string
+	v.(*p2cService).nodes.Range(func(key, _ interface{}) bool {
+		keys = append(keys, key.(string))
+		return true
+	})
+	if len(keys) != 2 {
+		t.Fatalf("stats kept for %v, want the nodes %s and %s", keys, a.Address, b.Address)
+	}
+	for _, key := range keys {
+		if key == c.Address {
+			t.Errorf("stats of the removed node %s kept", c.Address)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package selector
 
+import "context"
+
 // Selector obtains a service node through service discovery and load balancing
 type Selector interface {
-	Select(string) (string, error)
+	// Select picks the node of a call. The DoneFunc, if not nil, must be called once with the outcome of the call
+	Select(ctx context.Context, serviceName string, opts ...Option) (*Node, DoneFunc, error)
 }
 
 type defaultSelector struct {
@@ -10,10 +13,47 @@ type defaultSelector struct {
 
 // Options defines Selector options
 type Options struct {
+	BalancerName string             // overrides the balancer of the selector, e.g. : roundRobin、consistentHash、p2c
+	Filters      []func(*Node) bool // keep the nodes every filter accepts
 }
 
 type Option func(*Options)
 
+// WithBalancerName returns an Option which sets the balancer picking the node
+func WithBalancerName(name string) Option {
+	return func(o *Options) {
+		o.BalancerName = name
+	}
+}
+
+// WithFilter returns an Option which drops the nodes the filter rejects, e.g. : the nodes of another zone
+func WithFilter(filter func(*Node) bool) Option {
+	return func(o *Options) {
+		o.Filters = append(o.Filters, filter)
+	}
+}
+
+// Filter returns the nodes accepted by the filters of the options
+func (o *Options) Filter(nodes []*Node) []*Node {
+	if len(o.Filters) == 0 {
+		return nodes
+	}
+
+	accepted := make([]*Node, 0, len(nodes))
+	for _, node := range nodes {
+		ok := true
+		for _, filter := range o.Filters {
+			if ok = filter(node); !ok {
+				break
+			}
+		}
+		if ok {
+			accepted = append(accepted, node)
+		}
+	}
+	return accepted
+}
+
 func init() {
 	RegisterSelector("default", DefaultSelector)
 }
@@ -24,7 +64,6 @@ var DefaultSelector = &defaultSelector{}
 // 在微服务架构中，不同的选择器对应于不同的负载均衡策略
 var selectorMap = make(map[string]Selector)
 
-
 // RegisterSelector supports business custom registered Selector
 func RegisterSelector(name string, selector Selector) {
 	if selectorMap == nil {
@@ -33,8 +72,9 @@ func RegisterSelector(name string, selector Selector) {
 	selectorMap[name] = selector
 }
 
-func (d *defaultSelector) Select(serviceName string) (string, error) {
-	return "", nil
+// Select returns a node without address, the call is sent to its target
+func (d *defaultSelector) Select(ctx context.Context, serviceName string, opts ...Option) (*Node, DoneFunc, error) {
+	return &Node{}, nil, nil
 }
 
 // GetSelector get a selector by a given selector name
@@ -44,3 +84,27 @@ func GetSelector(name string) Selector {
 	}
 	return DefaultSelector
 }
+
+// LegacySelector is the former Selector interface, returning an address only
+type LegacySelector interface {
+	Select(string) (string, error)
+}
+
+type legacySelector struct {
+	LegacySelector
+}
+
+// AdaptLegacySelector returns a Selector of a LegacySelector, e.g. :
+// selector.RegisterSelector("mine", selector.AdaptLegacySelector(mySelector)).
+// The nodes only have an address and the Options are ignored
+func AdaptLegacySelector(s LegacySelector) Selector {
+	return &legacySelector{s}
+}
+
+func (l *legacySelector) Select(ctx context.Context, serviceName string, opts ...Option) (*Node, DoneFunc, error) {
+	addr, err := l.LegacySelector.Select(serviceName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Node{Address: addr}, nil, nil
+}
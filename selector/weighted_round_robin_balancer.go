@@ -83,11 +83,16 @@ func getWeightedNode(nodes []*Node) []*weightedNode {
 
 	var wgs []*weightedNode
 	for _, node := range nodes {
+		// nodes without weight get the default share
+		weight := node.Weight
+		if weight <= 0 {
+			weight = 1
+		}
 		wgs = append(wgs, &weightedNode{
 			node:            node,
-			weight:          node.weight,
-			currentWeight:   node.weight,
-			effectiveWeight: node.weight,
+			weight:          weight,
+			currentWeight:   weight,
+			effectiveWeight: weight,
 		})
 	}
 
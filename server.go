@@ -127,14 +127,14 @@ func getServiceMethods(serviceType reflect.Type, servieValue reflect.Value) ([]*
 			if len(ceps) == 0 {
 				//通过method.Func.Call完成了对方法的调用,其中Call的参数列表按照方法的参数列表顺序，以及类型填写
 				values := method.Func.Call([]reflect.Value{servieValue, reflect.ValueOf(ctx), reflect.ValueOf(req)})
-				return values[0].Interface(), nil
+				return methodResult(values)
 			}
 
 			// 执行拦截器
 			handler := func(ctx context.Context, reqbody interface{}) (interface{}, error) {
 				values := method.Func.Call([]reflect.Value{servieValue, reflect.ValueOf(ctx), reflect.ValueOf(req)})
 
-				return values[0].Interface(), nil
+				return methodResult(values)
 			}
 			return interceptor.ServerIntercept(ctx, req, ceps, handler)
 		}
@@ -149,6 +149,14 @@ func getServiceMethods(serviceType reflect.Type, servieValue reflect.Value) ([]*
 	return methods, nil
 }
 
+// methodResult returns the reply and the error returned by a service method
+func methodResult(values []reflect.Value) (interface{}, error) {
+	if err, ok := values[1].Interface().(error); ok && err != nil {
+		return nil, err
+	}
+	return values[0].Interface(), nil
+}
+
 func checkMethod(method reflect.Type) error {
 
 	// 要保证有两个自己给的参数，外加一个自己的参数 个数>=3
@@ -2,15 +2,22 @@ package rpcdemo
 
 import (
 	"context"
-	"errors"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"runtime/debug"
+	"strconv"
+	"time"
 
 	"github.com/HuaTug/My-RPC/codec"
 	"github.com/HuaTug/My-RPC/codes"
 	"github.com/HuaTug/My-RPC/interceptor"
 	"github.com/HuaTug/My-RPC/log"
 	"github.com/HuaTug/My-RPC/metadata"
+	"github.com/HuaTug/My-RPC/metrics"
+	"github.com/HuaTug/My-RPC/overload"
 	"github.com/HuaTug/My-RPC/protocol"
+	"github.com/HuaTug/My-RPC/stream"
 	"github.com/HuaTug/My-RPC/transport"
 	"github.com/HuaTug/My-RPC/utils"
 	"github.com/golang/protobuf/proto"
@@ -70,6 +77,20 @@ func (s *service) Serve(opts *ServerOptions) {
 		transport.WithServerTimeout(s.opts.timeout),
 		transport.WithSerializationType(s.opts.serializationType),
 		transport.WithProtocol(s.opts.protocol),
+		transport.WithWorkerPool(s.opts.workers, s.opts.queueSize),
+		transport.WithMaxQueueWait(s.opts.maxQueueWait),
+		transport.WithMaxConns(s.opts.maxConns),
+		transport.WithMaxConnsPerIP(s.opts.maxConnsPerIP),
+		transport.WithMaxConnRequests(s.opts.maxConnRequests),
+		transport.WithMaxConnAge(s.opts.maxConnAge),
+		transport.WithAllowCIDRs(s.opts.allowCIDRs...),
+		transport.WithDenyCIDRs(s.opts.denyCIDRs...),
+		transport.WithReadBufferSize(s.opts.readBufferSize),
+		transport.WithMaxPacketsInFlight(s.opts.maxPacketsInFlight),
+		transport.WithUdpDedupWindow(s.opts.udpDedupWindow),
+		transport.WithSocketMode(s.opts.socketMode),
+		transport.WithSocketGroup(s.opts.socketGroup),
+		transport.WithServerTransportAuth(s.opts.transportAuth),
 	}
 
 	serverTransport := transport.GetServerTransport(s.opts.protocol)
@@ -98,7 +119,7 @@ func (s *service) Name() string {
 	return s.serviceName
 }
 
-func (s *service) Handle(ctx context.Context, reqbuf []byte) ([]byte, error) {
+func (s *service) Handle(ctx context.Context, reqbuf []byte) (rspbuf []byte, err error) {
 
 	// parse protocol header
 	request := &protocol.Request{}
@@ -106,45 +127,145 @@ func (s *service) Handle(ctx context.Context, reqbuf []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	maxMetadataSize := s.opts.maxMetadataSize
+	if maxMetadataSize == 0 {
+		maxMetadataSize = metadata.DefaultMaxSize
+	}
+	if metadata.Size(request.Metadata) > maxMetadataSize {
+		return nil, codes.NewFrameworkError(codes.InvalidArgument, metadata.ErrTooLarge.Error())
+	}
+
+	ctx = metadata.NewIncomingContext(ctx, metadata.Decode(request.Metadata))
 	ctx = metadata.WithServerMetadata(ctx, request.Metadata)
 
+	// the handler deadline is the earliest of the caller deadline and the server timeout
+	timeout := s.opts.timeout
+	if v, ok := request.Metadata[metadata.TimeoutKey]; ok {
+		ms, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return nil, codes.NewFrameworkError(codes.InvalidArgument, "timeout is invalid")
+		}
+		// the caller has already given up, do not run the handler
+		if ms <= 0 {
+			return nil, codes.DeadlineExceededError
+		}
+		if callerTimeout := time.Duration(ms) * time.Millisecond; timeout == 0 || callerTimeout < timeout {
+			timeout = callerTimeout
+		}
+	}
+
+	if timeout != 0 {
+		// count the time spent waiting for a worker against the deadline
+		start, ok := transport.ReceivedAt(ctx)
+		if !ok {
+			start = time.Now()
+		}
+		deadline := start.Add(timeout)
+		if !time.Now().Before(deadline) {
+			return nil, codes.DeadlineExceededError
+		}
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	// shed excess load before the payload is deserialized
+	if s.opts.overloadLimiter != nil {
+		priority := overload.ParsePriority(string(request.Metadata[overload.PriorityKey]))
+		release, ok := s.opts.overloadLimiter.Acquire(priority)
+		if !ok {
+			return nil, codes.ServerOverloadError
+		}
+		start := time.Now()
+		defer func() {
+			release(time.Since(start), err)
+		}()
+	}
+
 	serverSerialization := codec.GetSerialization(s.opts.serializationType)
 
 	dec := func(req interface{}) error {
 
 		if err := serverSerialization.Unmarshal(request.Payload, req); err != nil {
-			return err
+			return codes.NewFrameworkError(codes.InvalidArgument, "request unmarshal failed: "+err.Error())
 		}
 		return nil
 	}
 
-	if s.opts.timeout != 0 {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, s.opts.timeout)
-		defer cancel()
+	serviceName, method, err := utils.ParseServicePath(string(request.ServicePath))
+	if err != nil {
+		return nil, codes.NewFrameworkError(codes.InvalidArgument, "method is invalid")
 	}
 
-	_, method, err := utils.ParseServicePath(string(request.ServicePath))
-	if err != nil {
-		return nil, codes.New(codes.ClientMsgErrorCode, "method is invalid")
+	// expose the called service and method, and the raw payload, to the interceptors
+	if ss, ok := ctx.Value(stream.ServerStreamKey).(*stream.ServerStream); ok {
+		ss.WithServiceName(serviceName).WithMethod(method).WithPayload(request.Payload)
+	}
+	// do not run the handler if the deadline passed before it could start
+	if ctx.Err() != nil {
+		return nil, codes.DeadlineExceededError
 	}
+
 	//ToDo 精彩
 	handler := s.handlers[method]
 	if handler == nil {
-		return nil, errors.New("handlers is nil")
+		return nil, codes.NewFrameworkError(codes.Unimplemented, "method not found: "+method)
 	}
 
 	// 执行了拦截器和方法
 	//logs.Println("interceptors :", s.opts.interceptors)
-	rsp, err := handler(ctx, s.svr, dec, s.opts.interceptors)
+	rsp, err := s.callHandler(ctx, handler, dec)
 	if err != nil {
 		return nil, err
 	}
 
-	rspbuf, err := serverSerialization.Marshal(rsp)
-	if err != nil {
-		return nil, err
+	return serverSerialization.Marshal(rsp)
+}
+
+// panicCounter counts the panics recovered in the handlers
+var panicCounter = metrics.GetCounter("gorpc_server_handler_panics_total")
+
+// callHandler runs the interceptors and the handler, a panic is converted into an Internal error
+// so that it never unwinds the transport goroutine and kills the server
+func (s *service) callHandler(ctx context.Context, handler Handler, dec func(interface{}) error) (rsp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = s.recoverPanic(ctx, p)
+		}
+	}()
+
+	return handler(ctx, s.svr, dec, s.opts.interceptors)
+}
+
+func (s *service) recoverPanic(ctx context.Context, p interface{}) error {
+	stack := debug.Stack()
+	requestID := getRequestID(ctx)
+
+	log.Errorf("handler panic recovered, request id: %s, panic: %v\n%s", requestID, p, stack)
+	panicCounter.Inc()
+
+	if s.opts.panicHandler != nil {
+		s.opts.panicHandler(ctx, requestID, p, stack)
+	}
+
+	return codes.NewFrameworkError(codes.Internal, "server panic, request id: "+requestID).
+		WithDetails(&codes.ErrorInfo{
+			Reason:   "PANIC",
+			Domain:   codes.Domain,
+			Metadata: map[string]string{"request_id": requestID},
+		})
+}
+
+// getRequestID returns the request ID set by the caller, or generates one
+func getRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(metadata.RequestIDKey); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
 	}
 
-	return rspbuf, nil
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
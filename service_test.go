@@ -0,0 +1,30 @@
+package rpcdemo
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/HuaTug/My-RPC/codes"
+	"github.com/HuaTug/My-RPC/metadata"
+	"github.com/HuaTug/My-RPC/protocol"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestRequestMetadataSizeLimit(t *testing.T) {
+	s := &service{opts: &ServerOptions{}}
+
+	reqbuf, err := proto.Marshal(&protocol.Request{
+		ServicePath: "/test.Greeter/SayHello",
+		Metadata:    map[string][]byte{"k": []byte(strings.Repeat("v", metadata.DefaultMaxSize))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Handle(context.Background(), reqbuf)
+	if codes.CodeOf(err) != codes.InvalidArgument || !strings.Contains(err.Error(), metadata.ErrTooLarge.Error()) {
+		t.Errorf("metadata over the default limit: error = %v, want %v", err, metadata.ErrTooLarge)
+	}
+}
@@ -10,6 +10,8 @@ type ClientStream struct {
 	ctx         context.Context
 	ServiceName string // service name
 	Method      string // method
+	// metadata of the response, set by the attempt whose response the call returns
+	RspMetadata map[string][]byte
 }
 
 func GetClientStream(ctx context.Context) *ClientStream {
@@ -25,7 +27,9 @@ func GetClientStream(ctx context.Context) *ClientStream {
 
 func (cs *ClientStream) Clone() *ClientStream {
 	return &ClientStream{
-		Method: cs.Method,
+		ctx:         cs.ctx,
+		ServiceName: cs.ServiceName,
+		Method:      cs.Method,
 	}
 }
 
@@ -50,3 +54,8 @@ func (cs *ClientStream) WithMethod(method string) {
 func (cs *ClientStream) WithServiceName(serviceName string) {
 	cs.ServiceName = serviceName
 }
+
+// WithClientStream returns a context carrying the stream, e.g. : a clone for one attempt of the call
+func WithClientStream(ctx context.Context, cs *ClientStream) context.Context {
+	return context.WithValue(ctx, ClientStreamKey, cs)
+}
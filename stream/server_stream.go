@@ -4,9 +4,11 @@ import "context"
 
 type ServerStream struct {
 	ctx context.Context
+	ServiceName string // 服务名
 	Method string // 方法名
 	RetCode uint32 // 返回码 0—成功 非0-失败
 	RetMsg  string  // 返回信息 OK-成功，失败返回具体信息
+	Payload []byte  // serialized request payload, e.g. : to verify a signature of the request
 }
 
 const ServerStreamKey = StreamContextKey("GORPC_SERVER_STREAM")
@@ -25,8 +27,19 @@ func (ss *ServerStream) WithMethod(method string) *ServerStream {
 	return ss
 }
 
+func (ss *ServerStream) WithPayload(payload []byte) *ServerStream {
+	ss.Payload = payload
+	return ss
+}
+
+func (ss *ServerStream) WithServiceName(serviceName string) *ServerStream {
+	ss.ServiceName = serviceName
+	return ss
+}
+
 func (ss *ServerStream) Clone() *ServerStream {
 	return &ServerStream{
+		ServiceName : ss.ServiceName,
 		Method : ss.Method,
 	}
 }
@@ -3,6 +3,7 @@ package transport
 import (
 	"time"
 
+	"github.com/HuaTug/My-RPC/auth"
 	connpool "github.com/HuaTug/My-RPC/pool"
 	"github.com/HuaTug/My-RPC/selector"
 )
@@ -13,7 +14,14 @@ type ClientTransportOptions struct {
 	Network     string
 	Pool        connpool.Pool
 	Selector    selector.Selector
-	Timeout     time.Duration
+	// SelectOptions are given to the selector for every call, e.g. : a filter of the nodes
+	SelectOptions []selector.Option
+	Timeout       time.Duration
+	// TransportAuth authenticates the connections, e.g. : with TLS, nil keeps them plaintext
+	TransportAuth auth.TransportAuth
+	// ResponseCheck returns the error answered in a response frame, so that the errors of the server count
+	// in the outcome of the call reported to the circuit breaker and the selector. nil takes every response as a success
+	ResponseCheck func(rsp []byte) error
 }
 
 type ClientTransportOption func(*ClientTransportOptions)
@@ -59,3 +67,24 @@ func WithTimeout(timeout time.Duration) ClientTransportOption {
 		o.Timeout = timeout
 	}
 }
+
+// WithClientTransportAuth returns a ClientTransportOption which sets the value for transportAuth
+func WithClientTransportAuth(transportAuth auth.TransportAuth) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.TransportAuth = transportAuth
+	}
+}
+
+// WithSelectOptions returns a ClientTransportOption which sets the value for selectOptions
+func WithSelectOptions(opts ...selector.Option) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.SelectOptions = opts
+	}
+}
+
+// WithResponseCheck returns a ClientTransportOption which sets the value for responseCheck
+func WithResponseCheck(check func(rsp []byte) error) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.ResponseCheck = check
+	}
+}
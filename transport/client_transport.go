@@ -3,8 +3,16 @@ package transport
 import (
 	"context"
 	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/HuaTug/My-RPC/breaker"
+	"github.com/HuaTug/My-RPC/codec"
 	"github.com/HuaTug/My-RPC/codes"
+	connpool "github.com/HuaTug/My-RPC/pool"
+	"github.com/HuaTug/My-RPC/selector"
 )
 
 type clientTransport struct {
@@ -47,69 +55,301 @@ var New = func() ClientTransport {
 
 func (c *clientTransport) Send(ctx context.Context, req []byte, opts ...ClientTransportOption) ([]byte, error) {
 
+	// the options apply to this call only, the transport is shared by the concurrent calls
+	callOpts := *c.opts
 	for _, o := range opts {
-		o(c.opts)
+		o(&callOpts)
 	}
+	call := &clientTransport{opts: &callOpts}
 
-	if c.opts.Network == "tcp" {
-		return c.SendTcpReq(ctx, req)
+	// a unix socket target carries its network, e.g. : unix:///run/app.sock
+	if network, path, ok := SplitUnixTarget(callOpts.Target); ok {
+		callOpts.Network = network
+		callOpts.Target = path
 	}
 
-	if c.opts.Network == "udp" {
-		return c.SendUdpReq(ctx, req)
+	// unix sockets are connection oriented, they are pooled like tcp connections
+	if callOpts.Network == "tcp" || isUnixNetwork(callOpts.Network) {
+		return call.SendTcpReq(ctx, req)
+	}
+
+	if callOpts.Network == "udp" {
+		return call.SendUdpReq(ctx, req)
 	}
 
 	return nil, codes.NetworkNotSupportedError
 }
 
-func (c *clientTransport) SendTcpReq(ctx context.Context, req []byte) ([]byte, error) {
+func (c *clientTransport) SendTcpReq(ctx context.Context, req []byte) (rsp []byte, err error) {
 
 	// service discovery
 	// 这里的c.opts.ServiceName表示为客户端的服务，即客户端可以发送想要调用的服务（服务名）
 	log.Println("SendTcpReq service_name: ", c.opts.ServiceName)
-	addr, err := c.opts.Selector.Select(c.opts.ServiceName)
+	addr, done, err := c.selectAddr(ctx)
 	log.Println("Select the addr is :", addr)
 	if err != nil {
 		return nil, err
 	}
 
-	// defaultSelector returns "", use the target as address
-	if addr == "" {
-		addr = c.opts.Target
+	// the node may be opened by its circuit breaker even if the selector did not skip it
+	report, err := c.admit(addr, done)
+	if err != nil {
+		return nil, err
 	}
+	// report the outcome and the latency of the call to the selector and the breaker
+	defer func() {
+		report(rsp, err)
+	}()
+
+	// the server did not handle a request answered with a GoAway frame, send it again
+	for attempt := 0; ; attempt++ {
+		rsp, err = c.roundTrip(ctx, addr, req)
+		if err != errGoAway || attempt == maxGoAwayRetries {
+			return rsp, err
+		}
+	}
+}
+
+// admit asks the circuit breaker of the node whether the request may be sent to addr. The returned
+// func reports the outcome of the call, including the error answered in the response, to the breaker
+// and to the DoneFunc of the selector
+func (c *clientTransport) admit(addr string, done selector.DoneFunc) (func(rsp []byte, err error), error) {
+	start := time.Now()
+
+	group := breaker.Get(c.opts.ServiceName)
+	if group != nil && !group.Allow(addr) {
+		err := codes.NewFrameworkError(codes.Unavailable, "circuit breaker open for node "+addr).
+			WithReason(codes.ReasonNodeCircuitOpen)
+		done(err, 0)
+		return nil, err
+	}
+
+	return func(rsp []byte, err error) {
+		if err == nil && c.opts.ResponseCheck != nil {
+			err = c.opts.ResponseCheck(rsp)
+		}
+		done(err, time.Since(start))
+		if group != nil {
+			group.Report(addr, err)
+		}
+	}, nil
+}
+
+// maxGoAwayRetries bounds how many pooled connections closed by the server a request is sent on
+const maxGoAwayRetries = 3
+
+// errGoAway is returned when the connection is being closed by the server
+var errGoAway = codes.NewFrameworkError(codes.Unavailable, "connection closed by the server").
+	WithReason(codes.ReasonConnectionClosing)
+
+// roundTrip sends a request on a pooled connection of addr and reads its response
+func (c *clientTransport) roundTrip(ctx context.Context, addr string, req []byte) ([]byte, error) {
 
 	// 表示为从连接池中获取连接
-	conn, err := c.opts.Pool.Get(ctx, c.opts.Network, addr)
+	conn, err := c.getConn(ctx, addr)
 	//	conn, err := net.DialTimeout("tcp", addr, c.opts.Timeout);
 	if err != nil {
 		return nil, err
 	}
 
 	defer conn.Close()
+
+	// bound the network reads and writes by the call deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// the frames go through the stream view of a unixpacket connection, the pooled connection is
+	// kept for closing and discarding it
+	rw := conn
+	if c.opts.Network == "unixpacket" {
+		rw = newSeqPacketConn(conn)
+	}
+
+	streamID := uint16(atomic.AddUint32(&streamIDs, 1))
+	codec.SetStreamID(req, streamID)
+
 	// 模仿gRPC 发送数据(分块发送数据)
 	sendNum := 0
 	num := 0
 	for sendNum < len(req) {
-		num, err = conn.Write(req[sendNum:])
+		num, err = rw.Write(req[sendNum:])
 		if err != nil {
 			return nil, err
 		}
 		sendNum += num
 
 		if err = isDone(ctx); err != nil {
+			// a partially written frame leaves the connection unusable
+			discard(conn)
 			return nil, err
 		}
 	}
+
+	// tell the server to stop the handler when the caller gives up waiting
+	watcher := newCancelWatcher(ctx, conn, streamID)
+	defer watcher.stop()
+
 	// parse frame
 	wrapperConn := wrapConn(conn)
 	// ReadFrame is for checking the frame header
-	frame, err := wrapperConn.framer.ReadFrame(conn)
+	frame, err := wrapperConn.framer.ReadFrame(rw)
+	if watcher.stop() {
+		return nil, ctx.Err()
+	}
 	if err != nil {
 		return nil, err
 	}
+
+	if codec.GetMsgType(frame) == codec.MsgTypeGoAway {
+		discard(conn)
+		return nil, errGoAway
+	}
+
+	// the connection is out of step with the server, e.g. : a late response of a cancelled request
+	if codec.GetStreamID(frame) != streamID {
+		discard(conn)
+		return nil, errStreamMismatch
+	}
 	return frame, err
 }
 
+// errStreamMismatch is returned when the response read does not belong to the request
+var errStreamMismatch = codes.NewFrameworkError(codes.Internal, "response stream ID does not match the request")
+
+// getConn returns a pooled connection of addr, authenticated when a TransportAuth is set
+func (c *clientTransport) getConn(ctx context.Context, addr string) (net.Conn, error) {
+	if c.opts.TransportAuth == nil {
+		return c.opts.Pool.Get(ctx, c.opts.Network, addr)
+	}
+
+	authPool, ok := c.opts.Pool.(connpool.AuthPool)
+	if !ok {
+		return nil, errAuthNotSupported
+	}
+
+	// the target names the server, the address may come from service discovery
+	authority := c.opts.Target
+	if authority == "" {
+		authority = addr
+	}
+	return authPool.GetAuthenticated(ctx, c.opts.Network, addr, authority, c.opts.TransportAuth)
+}
+
+// errAuthNotSupported is returned when the connections cannot be authenticated by the TransportAuth
+var errAuthNotSupported = codes.NewFrameworkError(codes.Unimplemented, "transport auth not supported by the connection pool or the network")
+
+// streamIDs generates the stream IDs of the requests
+var streamIDs uint32
+
+// cancelWatcher sends a cancel frame for a request whose context is done before its response arrived
+type cancelWatcher struct {
+	mu        sync.Mutex
+	done      chan struct{}
+	finished  bool
+	cancelled bool
+}
+
+func newCancelWatcher(ctx context.Context, conn net.Conn, streamID uint16) *cancelWatcher {
+	w := &cancelWatcher{
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-w.done:
+			return
+		}
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.finished {
+			return
+		}
+		w.cancelled = true
+
+		if frame, err := codec.NewCancelFrame(streamID); err == nil {
+			conn.Write(frame)
+		}
+		// a late response would be read by the next user of the connection
+		discard(conn)
+		// unblock the pending read
+		conn.SetReadDeadline(time.Now())
+	}()
+
+	return w
+}
+
+// stop stops watching, after it returns no cancel frame is sent anymore. It reports whether the request was cancelled
+func (w *cancelWatcher) stop() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.finished {
+		w.finished = true
+		close(w.done)
+	}
+	return w.cancelled
+}
+
+// discard prevents a pooled connection from being reused
+func discard(conn net.Conn) {
+	if pc, ok := conn.(*connpool.PoolConn); ok {
+		pc.MarkUnusable()
+	}
+}
+
+// maxReselect bounds how many times a node already claimed by a sibling attempt is re-picked
+const maxReselect = 3
+
+// selectAddr picks the address of the node to send the request to. When the context carries
+// a selector.Exclusion, nodes already used by other attempts of the same call are avoided.
+// The returned DoneFunc reports the outcome of the call to the selector, it is never nil
+func (c *clientTransport) selectAddr(ctx context.Context) (string, selector.DoneFunc, error) {
+	addr, done, err := c.selectOnce(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// defaultSelector returns a node without address, use the target as address
+	if addr == "" {
+		return c.opts.Target, done, nil
+	}
+
+	exclusion := selector.ExclusionFromContext(ctx)
+	if exclusion == nil {
+		return addr, done, nil
+	}
+
+	for i := 0; !exclusion.Claim(addr) && i < maxReselect; i++ {
+		next, nextDone, err := c.selectOnce(ctx)
+		if err != nil || next == "" {
+			nextDone(selector.ErrNotUsed, 0)
+			break
+		}
+		done(selector.ErrNotUsed, 0)
+		addr, done = next, nextDone
+	}
+
+	return addr, done, nil
+}
+
+// selectOnce asks the selector for a node and returns its address
+func (c *clientTransport) selectOnce(ctx context.Context) (string, selector.DoneFunc, error) {
+	node, done, err := c.opts.Selector.Select(ctx, c.opts.ServiceName, c.opts.SelectOptions...)
+	if done == nil {
+		done = func(error, time.Duration) {}
+	}
+	if err != nil {
+		return "", done, err
+	}
+	if node == nil {
+		return "", done, nil
+	}
+	return node.Address, done, nil
+}
+
 // isDone 判断是否超时或者被异常中断
 func isDone(ctx context.Context) error {
 	select {
@@ -0,0 +1,165 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/HuaTug/My-RPC/breaker"
+	"github.com/HuaTug/My-RPC/codec"
+	"github.com/HuaTug/My-RPC/codes"
+	connpool "github.com/HuaTug/My-RPC/pool"
+	"github.com/HuaTug/My-RPC/protocol"
+	"github.com/HuaTug/My-RPC/selector"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestAdmitOpenNode(t *testing.T) {
+	group := breaker.NewGroup(breaker.WithConsecutiveFailures(1))
+	breaker.Register("test.NodeOpen", group)
+	group.Allow("127.0.0.1:8000")
+	group.Report("127.0.0.1:8000", errors.New("connection reset"))
+
+	c := &clientTransport{opts: &ClientTransportOptions{ServiceName: "test.NodeOpen"}}
+	var reported error
+	_, err := c.admit("127.0.0.1:8000", func(err error, _ time.Duration) { reported = err })
+
+	if !codes.HasReason(err, codes.ReasonNodeCircuitOpen) || codes.HasReason(err, codes.ReasonCircuitOpen) {
+		t.Errorf("admit() error = %v, want the reason %s only", err, codes.ReasonNodeCircuitOpen)
+	}
+	if reported != err {
+		t.Errorf("selector told %v, want %v", reported, err)
+	}
+}
+
+// blockingHandler blocks the "block" requests until their context is done, it answers the
+// other requests right away
+type blockingHandler struct {
+	started   chan struct{}
+	cancelled chan error
+}
+
+func (h *blockingHandler) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	if string(req) != "block" {
+		return req, nil
+	}
+
+	close(h.started)
+	<-ctx.Done()
+	h.cancelled <- ctx.Err()
+	return []byte("late"), nil
+}
+
+// sendTo sends a request of body to addr through the pool
+func sendTo(t *testing.T, ctx context.Context, addr string, pool connpool.Pool, body string) (string, error) {
+	frame, err := New().Send(ctx, requestFrame(t, 0, body),
+		WithClientNetwork("tcp"),
+		WithClientTarget(addr),
+		WithClientPool(pool),
+		WithSelector(selector.DefaultSelector),
+		WithTimeout(5*time.Second),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	rspbuf, err := codec.GetCodec("proto").Decode(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp := &protocol.Response{}
+	if err := proto.Unmarshal(rspbuf, rsp); err != nil {
+		t.Fatal(err)
+	}
+	return string(rsp.Payload), nil
+}
+
+func TestClientCancelStopsServerHandler(t *testing.T) {
+	h := &blockingHandler{started: make(chan struct{}), cancelled: make(chan error, 1)}
+	addr := startTcpServer(t, &ServerTransportOptions{Handler: h})
+	pool := connpool.NewConnPool()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-h.started
+		cancel()
+	}()
+	if _, err := sendTo(t, ctx, addr, pool, "block"); err == nil {
+		t.Fatal("Send() of a cancelled request succeeded")
+	}
+
+	select {
+	case err := <-h.cancelled:
+		if err != context.Canceled {
+			t.Errorf("handler context error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler context not cancelled by the client")
+	}
+
+	if rsp, err := sendTo(t, context.Background(), addr, pool, "next"); err != nil || rsp != "next" {
+		t.Errorf("Send() after a cancelled request = %q, %v, want next", rsp, err)
+	}
+}
+
+func TestLateResponseNotReadByNextCall(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	// the server answers the "block" requests late, even when they were cancelled
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		framer := NewFramer()
+		for {
+			frame, err := framer.ReadFrame(conn)
+			if err != nil {
+				return
+			}
+			if codec.GetMsgType(frame) == codec.MsgTypeCancel {
+				continue
+			}
+			body, _ := codec.GetCodec("proto").Decode(frame)
+			if string(body) == "block" {
+				time.Sleep(100 * time.Millisecond)
+				body = []byte("late")
+			}
+
+			rspbuf, _ := proto.Marshal(&protocol.Response{Payload: body})
+			rsp, _ := codec.GetCodec("proto").Encode(rspbuf)
+			codec.SetStreamID(rsp, codec.GetStreamID(frame))
+			conn.Write(rsp)
+		}
+	}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	addr := lis.Addr().String()
+	pool := connpool.NewConnPool()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := sendTo(t, ctx, addr, pool, "block"); err == nil {
+		t.Fatal("Send() of a cancelled request succeeded")
+	}
+
+	// the late response arrives on the connection of the cancelled request
+	time.Sleep(150 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if rsp, err := sendTo(t, context.Background(), addr, pool, "next"); err != nil || rsp != "next" {
+			t.Errorf("Send() %d after a cancelled request = %q, %v, want next", i, rsp, err)
+		}
+	}
+}
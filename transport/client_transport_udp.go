@@ -3,21 +3,32 @@ package transport
 import (
 	"context"
 	"net"
+	"time"
 
 	"github.com/HuaTug/My-RPC/codes"
 )
 
-func (c *clientTransport) SendUdpReq(ctx context.Context, req []byte) ([]byte, error) {
+func (c *clientTransport) SendUdpReq(ctx context.Context, req []byte) (rsp []byte, err error) {
+	// datagrams cannot be secured by a connection handshake
+	if c.opts.TransportAuth != nil {
+		return nil, errAuthNotSupported
+	}
+
 	// service discovery
-	addr, err := c.opts.Selector.Select(c.opts.ServiceName)
+	addr, done, err := c.selectAddr(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// defaultSelector returns "", use the target as address
-	if addr == "" {
-		addr = c.opts.Target
+	// the node may be opened by its circuit breaker even if the selector did not skip it
+	report, err := c.admit(addr, done)
+	if err != nil {
+		return nil, err
 	}
+	// report the outcome and the latency of the call to the selector and the breaker
+	defer func() {
+		report(rsp, err)
+	}()
 
 	udpAddr, err := net.ResolveUDPAddr(c.opts.Network, addr)
 	if err != nil {
@@ -31,17 +42,138 @@ func (c *clientTransport) SendUdpReq(ctx context.Context, req []byte) ([]byte, e
 
 	defer conn.Close()
 
-	if n, err := conn.Write(req); n != len(req) || err != nil {
-		return nil, err
+	// the response datagrams arrive in a burst
+	conn.SetReadBuffer(defaultUdpReadBuffer)
+
+	// a lost datagram is retransmitted until the deadline, the call must have one
+	if _, ok := ctx.Deadline(); !ok {
+		timeout := c.opts.Timeout
+		if timeout <= 0 {
+			timeout = defaultUdpTimeout
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
+	deadline, _ := ctx.Deadline()
 
-	recvBuf := make([]byte, 65536)
-	n, err := conn.Read(recvBuf)
+	// unblock the pending read when the caller gives up
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	requestID := nextUdpRequestID()
+	datagrams, err := encodeDatagrams(requestID, req)
 	if err != nil {
-		return nil, err
+		return nil, codes.NewFrameworkError(codes.ClientMsgErrorCode, err.Error())
 	}
 
-	rsp := recvBuf[:n]
+	var response *fragments
+	var acked []bool // the request fragments received by the server
+	recvBuf := make([]byte, maxPacketSize)
+	interval := udpRetransmitInterval
+
+	for retransmission := 0; ; retransmission++ {
+		send := datagrams
+		if retransmission > 0 {
+			send = udpRetransmission(requestID, datagrams, acked, response, retransmission)
+		}
+		for _, datagram := range send {
+			if _, err := conn.Write(datagram); err != nil {
+				return nil, err
+			}
+		}
+
+		retransmitAt := time.Now().Add(interval)
+		if retransmitAt.After(deadline) {
+			retransmitAt = deadline
+		}
+		conn.SetReadDeadline(retransmitAt)
+
+		progress := false
+		for {
+			n, err := conn.Read(recvBuf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+
+			h, payload, err := decodeDatagram(recvBuf[:n])
+			// a late response of a previous call on a reused port
+			if err != nil || h.requestID != requestID {
+				continue
+			}
+
+			progress = true
+
+			if h.flags&udpFlagAck != 0 {
+				if a := ackedFragments(payload, len(datagrams)); a != nil {
+					acked = a
+				}
+				continue
+			}
+
+			if response == nil {
+				response = newFragments(h.count)
+			}
+			if response.add(h, payload) {
+				return response.bytes(), nil
+			}
+		}
 
-	return rsp, nil
+		if !time.Now().Before(deadline) {
+			return nil, context.DeadlineExceeded
+		}
+
+		// back off while the server does not answer
+		if progress {
+			interval = udpRetransmitInterval
+		} else if interval *= 2; interval > maxUdpRetransmitInterval {
+			interval = maxUdpRetransmitInterval
+		}
+	}
+}
+
+// udpRetransmission returns the datagrams to send when no response arrived in time : the request
+// fragments the server misses, or once it has them all, an ack of the response fragments received
+// so that the server sends the missing ones
+func udpRetransmission(requestID uint64, datagrams [][]byte, acked []bool, response *fragments,
+	retransmission int) [][]byte {
+
+	if response != nil || allAcked(acked) {
+		return [][]byte{encodeAck(requestID, response)}
+	}
+
+	missing := missingDatagrams(datagrams, acked, retransmission)
+	for i, datagram := range missing {
+		retransmitted := append([]byte(nil), datagram...)
+		retransmitted[1] |= udpFlagRetransmit
+		missing[i] = retransmitted
+	}
+	return missing
 }
+
+func allAcked(acked []bool) bool {
+	if acked == nil {
+		return false
+	}
+	for _, a := range acked {
+		if !a {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	defaultUdpTimeout        = 5 * time.Second        // bounds the udp calls without deadline
+	defaultUdpReadBuffer     = 4 << 20                // socket receive buffer, capped by the system
+	udpRetransmitInterval    = 100 * time.Millisecond // retransmission delay, doubled while the server does not answer
+	maxUdpRetransmitInterval = time.Second
+)
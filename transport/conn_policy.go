@@ -0,0 +1,150 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HuaTug/My-RPC/metrics"
+)
+
+var (
+	connsGauge           = metrics.GetGauge("gorpc_server_conns")
+	connsRejectedCounter = metrics.GetCounter("gorpc_server_conns_rejected_total")
+	connsGoAwayCounter   = metrics.GetCounter("gorpc_server_conns_goaway_total")
+)
+
+// goAwayGrace is how long a connection is kept half-closed after the GoAway frame, so that
+// the client notices it before the connection is gone. It exceeds the pool health check interval
+const goAwayGrace = 5 * time.Second
+
+// connLimiter bounds the number of concurrent connections, in total and per remote IP
+type connLimiter struct {
+	maxConns      int // 0 means no limit
+	maxConnsPerIP int // 0 means no limit
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+}
+
+func newConnLimiter(maxConns int, maxConnsPerIP int) *connLimiter {
+	return &connLimiter{
+		maxConns:      maxConns,
+		maxConnsPerIP: maxConnsPerIP,
+		perIP:         make(map[string]int),
+	}
+}
+
+// acquire reserves a connection slot for ip, it returns false when a limit is reached.
+// An empty ip, e.g. : of a unix peer, only counts towards the total
+func (l *connLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxConns > 0 && l.total >= l.maxConns {
+		return false
+	}
+	if ip != "" && l.maxConnsPerIP > 0 && l.perIP[ip] >= l.maxConnsPerIP {
+		return false
+	}
+
+	l.total++
+	if ip != "" {
+		l.perIP[ip]++
+	}
+	connsGauge.Add(1)
+	return true
+}
+
+// release frees the connection slot of ip
+func (l *connLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if ip != "" {
+		if l.perIP[ip]--; l.perIP[ip] <= 0 {
+			delete(l.perIP, ip)
+		}
+	}
+	connsGauge.Add(-1)
+}
+
+// ipFilter checks the remote IP of the accepted connections. Denied networks win over
+// allowed ones, and when allowed networks are set any other IP is denied
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func newIPFilter(allow []string, deny []string) (*ipFilter, error) {
+	var err error
+	f := &ipFilter{}
+
+	if f.allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// parseCIDRs parses CIDR notations, a bare IP is a network of a single address
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func (f *ipFilter) permitted(ip net.IP) bool {
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP returns the IP of a tcp or udp address
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
@@ -2,17 +2,35 @@ package transport
 
 import (
 	"context"
+	"os"
 	"time"
+
+	"github.com/HuaTug/My-RPC/auth"
 )
 
 type ServerTransportOptions struct {
-	Address           string        // address，e.g: ip://127.0.0.1：8080
-	Network           string        // network type
-	Protocol          string        // protocol type, e.g. : proto、json
-	Timeout           time.Duration // transport layer request timeout ，default: 2 min
-	Handler           Handler       // handler
-	SerializationType string        // serialization type, e.g : proto、json、msgpack
-	KeepAlivePeriod   time.Duration // keepalive period
+	Address            string             // address，e.g: ip://127.0.0.1：8080
+	Network            string             // network type
+	Protocol           string             // protocol type, e.g. : proto、json
+	Timeout            time.Duration      // transport layer request timeout ，default: 2 min
+	Handler            Handler            // handler
+	SerializationType  string             // serialization type, e.g : proto、json、msgpack
+	KeepAlivePeriod    time.Duration      // keepalive period
+	Workers            int                // size of the request worker pool, 0 runs every request on its own goroutine
+	QueueSize          int                // number of requests waiting for a worker, beyond which they are rejected
+	MaxQueueWait       time.Duration      // requests waiting longer for a worker are dropped, 0 means no limit
+	MaxConns           int                // maximum concurrent tcp connections, 0 means no limit
+	MaxConnsPerIP      int                // maximum concurrent tcp connections of a remote IP, 0 means no limit
+	MaxConnRequests    int                // requests served by a tcp connection before it is gracefully closed, 0 means no limit
+	MaxConnAge         time.Duration      // lifetime of a tcp connection before it is gracefully closed, 0 means no limit
+	AllowCIDRs         []string           // networks allowed to connect, empty allows all of them
+	DenyCIDRs          []string           // networks denied to connect, checked before AllowCIDRs
+	ReadBufferSize     int                // udp socket receive buffer size (SO_RCVBUF), default: 4 MiB capped by the system
+	MaxPacketsInFlight int                // udp packets handled concurrently, default: 1024
+	UdpDedupWindow     time.Duration      // how long the udp responses are kept for retransmitted requests, default: 10s
+	SocketMode         os.FileMode        // unix socket file mode, 0 keeps the mode given by the umask
+	SocketGroup        string             // unix socket file group, name or gid, empty keeps the group of the process
+	TransportAuth      auth.TransportAuth // authenticates the accepted connections, e.g. : with TLS, nil keeps them plaintext
 }
 
 type Handler interface {
@@ -69,3 +87,102 @@ func WithKeepAlivePeriod(keepAlivePeriod time.Duration) ServerTransportOption {
 		o.KeepAlivePeriod = keepAlivePeriod
 	}
 }
+
+// WithWorkerPool returns a ServerTransportOption which sets the size of the worker pool and of its queue
+func WithWorkerPool(workers int, queueSize int) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.Workers = workers
+		o.QueueSize = queueSize
+	}
+}
+
+// WithMaxQueueWait returns a ServerTransportOption which sets the value for maxQueueWait
+func WithMaxQueueWait(maxQueueWait time.Duration) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.MaxQueueWait = maxQueueWait
+	}
+}
+
+// WithMaxConns returns a ServerTransportOption which sets the value for maxConns
+func WithMaxConns(maxConns int) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.MaxConns = maxConns
+	}
+}
+
+// WithMaxConnsPerIP returns a ServerTransportOption which sets the value for maxConnsPerIP
+func WithMaxConnsPerIP(maxConnsPerIP int) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.MaxConnsPerIP = maxConnsPerIP
+	}
+}
+
+// WithMaxConnRequests returns a ServerTransportOption which sets the value for maxConnRequests
+func WithMaxConnRequests(maxConnRequests int) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.MaxConnRequests = maxConnRequests
+	}
+}
+
+// WithMaxConnAge returns a ServerTransportOption which sets the value for maxConnAge
+func WithMaxConnAge(maxConnAge time.Duration) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.MaxConnAge = maxConnAge
+	}
+}
+
+// WithAllowCIDRs returns a ServerTransportOption which sets the networks allowed to connect
+func WithAllowCIDRs(cidrs ...string) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.AllowCIDRs = cidrs
+	}
+}
+
+// WithDenyCIDRs returns a ServerTransportOption which sets the networks denied to connect
+func WithDenyCIDRs(cidrs ...string) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.DenyCIDRs = cidrs
+	}
+}
+
+// WithReadBufferSize returns a ServerTransportOption which sets the udp socket receive buffer size
+func WithReadBufferSize(size int) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.ReadBufferSize = size
+	}
+}
+
+// WithMaxPacketsInFlight returns a ServerTransportOption which sets the value for maxPacketsInFlight
+func WithMaxPacketsInFlight(maxPacketsInFlight int) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.MaxPacketsInFlight = maxPacketsInFlight
+	}
+}
+
+// WithUdpDedupWindow returns a ServerTransportOption which sets the value for udpDedupWindow
+func WithUdpDedupWindow(window time.Duration) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.UdpDedupWindow = window
+	}
+}
+
+// WithSocketMode returns a ServerTransportOption which sets the unix socket file mode
+func WithSocketMode(mode os.FileMode) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.SocketMode = mode
+	}
+}
+
+// WithSocketGroup returns a ServerTransportOption which sets the unix socket file group
+func WithSocketGroup(group string) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.SocketGroup = group
+	}
+}
+
+// WithServerTransportAuth returns a ServerTransportOption which sets the value for transportAuth
+func WithServerTransportAuth(transportAuth auth.TransportAuth) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.TransportAuth = transportAuth
+	}
+}
@@ -5,11 +5,18 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/HuaTug/My-RPC/log"
+	"github.com/HuaTug/My-RPC/auth"
 	"github.com/HuaTug/My-RPC/codec"
 	"github.com/HuaTug/My-RPC/codes"
+	"github.com/HuaTug/My-RPC/log"
+	"github.com/HuaTug/My-RPC/metadata"
+	"github.com/HuaTug/My-RPC/metrics"
+	"github.com/HuaTug/My-RPC/peer"
 	"github.com/HuaTug/My-RPC/protocol"
 	"github.com/HuaTug/My-RPC/stream"
 	"github.com/HuaTug/My-RPC/utils"
@@ -18,7 +25,11 @@ import (
 )
 
 type serverTransport struct {
-	opts *ServerTransportOptions
+	opts   *ServerTransportOptions
+	pool   *workerPool  // runs the requests when ServerTransportOptions.Workers is set
+	conns  *connLimiter // bounds the concurrent connections
+	filter *ipFilter    // checks the remote IP of the accepted connections
+	udp    *udpRequests // reassembles and deduplicates the udp requests
 }
 
 var serverTransportMap = make(map[string]ServerTransport)
@@ -61,11 +72,29 @@ func (s *serverTransport) ListenAndServe(ctx context.Context, opts ...ServerTran
 		o(s.opts)
 	}
 
+	filter, err := newIPFilter(s.opts.AllowCIDRs, s.opts.DenyCIDRs)
+	if err != nil {
+		return err
+	}
+	s.filter = filter
+	s.conns = newConnLimiter(s.opts.MaxConns, s.opts.MaxConnsPerIP)
+
+	if s.opts.Workers > 0 {
+		s.pool = newWorkerPool(ctx, s.opts.Workers, s.opts.QueueSize, s.opts.MaxQueueWait)
+	}
+
+	// the address of a unix socket may carry its network, e.g. : unix:///run/app.sock
+	if network, _, ok := SplitUnixTarget(s.opts.Address); ok {
+		s.opts.Network = network
+	}
+
 	switch s.opts.Network {
 	case "tcp", "tcp4", "tcp6":
 		return s.ListenAndServeTcp(ctx, opts...)
 	case "udp", "udp4", "udp6":
 		return s.ListenAndServeUdp(ctx, opts...)
+	case "unix", "unixpacket":
+		return s.ListenAndServeUnix(ctx, opts...)
 	default:
 		return codes.NetworkNotSupportedError
 	}
@@ -93,11 +122,6 @@ func (s *serverTransport) serve(ctx context.Context, lis net.Listener) error {
 
 	var tempDelay time.Duration
 
-	tl, ok := lis.(*net.TCPListener)
-	if !ok {
-		return codes.NetworkNotSupportedError
-	}
-
 	for {
 
 		// check upstream ctx is done
@@ -107,8 +131,12 @@ func (s *serverTransport) serve(ctx context.Context, lis net.Listener) error {
 		default:
 		}
 
-		conn, err := tl.AcceptTCP()
+		conn, err := lis.Accept()
 		if err != nil {
+			// the listener is closed by the server stop
+			if ctx.Err() != nil {
+				return nil
+			}
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				if tempDelay == 0 {
 					tempDelay = 5 * time.Millisecond
@@ -123,22 +151,66 @@ func (s *serverTransport) serve(ctx context.Context, lis net.Listener) error {
 			}
 			return err
 		}
+		tempDelay = 0
+
+		// unix peers have no IP, the IP filters do not apply to them and they share the connection limit
+		var key string
+		ip := addrIP(conn.RemoteAddr())
+		if ip != nil {
+			key = ip.String()
+			if !s.filter.permitted(ip) {
+				connsRejectedCounter.Inc()
+				log.Debugf("connection from %s denied", ip)
+				conn.Close()
+				continue
+			}
+		}
 
-		if err = conn.SetKeepAlive(true); err != nil {
-			return err
+		if !s.conns.acquire(key) {
+			connsRejectedCounter.Inc()
+			log.Debugf("connection from %s rejected, too many connections", conn.RemoteAddr())
+			conn.Close()
+			continue
 		}
 
-		if s.opts.KeepAlivePeriod != 0 {
-			conn.SetKeepAlivePeriod(s.opts.KeepAlivePeriod)
+		connCtx := ctx
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			if err = c.SetKeepAlive(true); err != nil {
+				s.conns.release(key)
+				conn.Close()
+				return err
+			}
+
+			if s.opts.KeepAlivePeriod != 0 {
+				c.SetKeepAlivePeriod(s.opts.KeepAlivePeriod)
+			}
+		case *net.UnixConn:
+			if cred, err := peerCred(c); err == nil {
+				connCtx = withPeerCred(ctx, cred)
+			} else {
+				log.Debugf("read peer credentials err, %v", err)
+			}
+
+			if s.opts.Network == "unixpacket" {
+				conn = newSeqPacketConn(c)
+			}
 		}
 
 		go func() {
+			defer s.conns.release(key)
+			defer recoverPanic()
+
+			conn, connCtx, err := s.handshake(connCtx, conn)
+			if err != nil {
+				log.Errorf("gorpc handshake with %s error, %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			connCtx = s.withPeer(connCtx, conn)
 
-			// build stream
-			ctx, _ := stream.NewServerStream(ctx)
-
-			if err := s.handleConn(ctx, wrapConn(conn)); err != nil {
-				log.Errorf("gorpc handle tcp conn error, %v", err)
+			if err := s.handleConn(connCtx, wrapConn(conn)); err != nil {
+				log.Errorf("gorpc handle conn error, %v", err)
 			}
 
 		}()
@@ -146,12 +218,76 @@ func (s *serverTransport) serve(ctx context.Context, lis net.Listener) error {
 	}
 }
 
+// handshakeTimeout bounds the handshake of the TransportAuth of an accepted connection
+const handshakeTimeout = 10 * time.Second
+
+// handshake authenticates an accepted connection with the TransportAuth, the returned context
+// carries its AuthInfo. The connection is returned as is when there is no TransportAuth
+func (s *serverTransport) handshake(ctx context.Context, conn net.Conn) (net.Conn, context.Context, error) {
+	if s.opts.TransportAuth == nil {
+		return conn, ctx, nil
+	}
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	authConn, info, err := s.opts.TransportAuth.ServerHandshake(conn)
+	if err != nil {
+		return conn, ctx, err
+	}
+	authConn.SetDeadline(time.Time{})
+
+	if info != nil {
+		ctx = auth.NewContextWithAuthInfo(ctx, info)
+	}
+	return authConn, ctx, nil
+}
+
+// connIDs numbers the accepted connections
+var connIDs uint64
+
+// withPeer returns a context carrying the peer of an accepted connection
+func (s *serverTransport) withPeer(ctx context.Context, conn net.Conn) context.Context {
+	p := &peer.Peer{
+		Addr:      conn.RemoteAddr(),
+		LocalAddr: conn.LocalAddr(),
+		ConnID:    atomic.AddUint64(&connIDs, 1),
+	}
+	if info, ok := auth.AuthInfoFromContext(ctx); ok {
+		p.AuthInfo = info
+	}
+	return peer.NewContext(ctx, p)
+}
+
 func (s *serverTransport) handleConn(ctx context.Context, conn *connWrapper) error {
 
 	// close the connection before return
 	// the connection closes only if a network read or write fails
 	defer conn.Close()
 
+	// the connection stops taking requests once it served MaxConnRequests or lived MaxConnAge,
+	// the GoAway frame moves the client to a new connection
+	var draining bool
+	var goAwayDone <-chan struct{}
+	defer func() {
+		if goAwayDone != nil {
+			<-goAwayDone
+		}
+	}()
+
+	var requests int
+	var expiresAt time.Time
+	if s.opts.MaxConnAge > 0 {
+		expiresAt = time.Now().Add(s.opts.MaxConnAge)
+	}
+
+	// requests of a connection are handled concurrently, so that cancel frames
+	// can be read while their request is running
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	streams := newStreamTable()
+	// nobody waits for the responses once the connection is gone
+	defer streams.cancelAll()
+
 	for {
 		// check upstream ctx is done
 		select {
@@ -160,6 +296,22 @@ func (s *serverTransport) handleConn(ctx context.Context, conn *connWrapper) err
 		default:
 		}
 
+		// the connection expires between two frames, a frame being read is never cut
+		if !draining && !expiresAt.IsZero() {
+			err := conn.waitFrame(expiresAt)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				draining = true
+				goAwayDone = s.goAway(conn, &wg)
+				continue
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+
 		frame, err := s.read(ctx, conn)
 		if err == io.EOF {
 			// read compeleted
@@ -167,19 +319,201 @@ func (s *serverTransport) handleConn(ctx context.Context, conn *connWrapper) err
 		}
 
 		if err != nil {
+			// the grace period after the GoAway frame is over
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && draining {
+				return nil
+			}
 			return err
 		}
 
-		rsp, err := s.handle(ctx, frame)
+		streamID := codec.GetStreamID(frame)
+
+		if codec.GetMsgType(frame) == codec.MsgTypeCancel {
+			streams.cancel(streamID)
+			continue
+		}
+
+		// the request is not handled, the client sends it again on another connection
+		if draining {
+			s.refuse(conn, streamID)
+			continue
+		}
+
+		reqCtx, cancel := context.WithCancel(withReceivedAt(ctx, time.Now()))
+		entry := streams.add(streamID, cancel)
+
+		wg.Add(1)
+		finish := func() {
+			streams.remove(streamID, entry)
+			wg.Done()
+		}
+
+		// the caller cancelled the request and does not read the response anymore
+		cancelled := func() bool {
+			return reqCtx.Err() == context.Canceled && ctx.Err() == nil
+		}
+
+		s.dispatch(&task{
+			ctx: reqCtx,
+			run: func() {
+				defer finish()
+
+				// build stream
+				reqCtx, _ := stream.NewServerStream(reqCtx)
+
+				rsp, err := s.handle(reqCtx, frame)
+				if err != nil {
+					log.Errorf("s.handle err is not nil, %v", err)
+					// the caller waits for a response of the stream
+					s.writeError(reqCtx, conn, streamID, err)
+					return
+				}
+
+				if cancelled() {
+					return
+				}
+
+				codec.SetStreamID(rsp, streamID)
+				if err := s.write(reqCtx, conn, rsp); err != nil {
+					log.Errorf("conn write err, %v", err)
+				}
+			},
+			reject: func(err error) {
+				defer finish()
+
+				if cancelled() {
+					return
+				}
+
+				s.writeError(reqCtx, conn, streamID, err)
+			},
+		})
+
+		if requests++; s.opts.MaxConnRequests > 0 && requests >= s.opts.MaxConnRequests {
+			draining = true
+			goAwayDone = s.goAway(conn, &wg)
+		}
+	}
+
+}
+
+// goAway sends the GoAway frame once the in-flight requests of the connection are answered,
+// then half-closes the connection, which is closed after goAwayGrace at the latest
+func (s *serverTransport) goAway(conn *connWrapper, wg *sync.WaitGroup) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer recoverPanic()
+
+		wg.Wait()
+		connsGoAwayCounter.Inc()
+
+		frame, err := codec.NewGoAwayFrame()
+		if err == nil {
+			conn.writeMu.Lock()
+			_, err = conn.Write(frame)
+			conn.writeMu.Unlock()
+		}
 		if err != nil {
-			log.Errorf("s.handle err is not nil, %v", err)
+			log.Errorf("send goaway frame err, %v", err)
+			conn.SetReadDeadline(time.Now())
+			return
 		}
 
-		if err = s.write(ctx, conn, rsp); err != nil {
-			return err
+		if cw, ok := conn.Conn.(closeWriter); ok {
+			cw.CloseWrite()
 		}
+		conn.SetReadDeadline(time.Now().Add(goAwayGrace))
+	}()
+
+	return done
+}
+
+// writeError answers the request of a stream with an error response
+func (s *serverTransport) writeError(ctx context.Context, conn *connWrapper, streamID uint16, err error) {
+	rsp, err := s.errorFrame(err)
+	if err != nil {
+		log.Errorf("build error frame err, %v", err)
+		return
+	}
+
+	codec.SetStreamID(rsp, streamID)
+	if err := s.write(ctx, conn, rsp); err != nil {
+		log.Errorf("conn write err, %v", err)
+	}
+}
+
+// refuse answers a request received while the connection is draining with a GoAway frame
+// carrying its stream ID. Once the last GoAway frame is sent the write side is closed, the
+// client reads that one instead
+func (s *serverTransport) refuse(conn *connWrapper, streamID uint16) {
+	frame, err := codec.NewGoAwayFrame()
+	if err != nil {
+		log.Errorf("build goaway frame err, %v", err)
+		return
+	}
+	codec.SetStreamID(frame, streamID)
+
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+	conn.Write(frame)
+}
+
+// streamTable holds the cancel functions of the requests running on a connection
+type streamTable struct {
+	mu      sync.Mutex
+	entries map[uint16]*streamEntry
+}
+
+type streamEntry struct {
+	cancel context.CancelFunc
+}
+
+func newStreamTable() *streamTable {
+	return &streamTable{
+		entries: make(map[uint16]*streamEntry),
 	}
+}
+
+func (t *streamTable) add(streamID uint16, cancel context.CancelFunc) *streamEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
+	entry := &streamEntry{cancel: cancel}
+	t.entries[streamID] = entry
+	return entry
+}
+
+func (t *streamTable) remove(streamID uint16, entry *streamEntry) {
+	entry.cancel()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// the stream ID may have been reused by a newer request
+	if t.entries[streamID] == entry {
+		delete(t.entries, streamID)
+	}
+}
+
+func (t *streamTable) cancel(streamID uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if entry, ok := t.entries[streamID]; ok {
+		entry.cancel()
+		delete(t.entries, streamID)
+	}
+}
+
+func (t *streamTable) cancelAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for streamID, entry := range t.entries {
+		entry.cancel()
+		delete(t.entries, streamID)
+	}
 }
 
 func (s *serverTransport) read(ctx context.Context, conn *connWrapper) ([]byte, error) {
@@ -202,16 +536,31 @@ func (s *serverTransport) handle(ctx context.Context, frame []byte) ([]byte, err
 	reqbuf, err := serverCodec.Decode(frame)
 	if err != nil {
 		log.Errorf("server Decode error: %v", err)
-		return nil, err
+		return nil, codes.NewFrameworkError(codes.InvalidArgument, "request decode failed")
 	}
 
+	// collect the headers and trailers set by the interceptors and the handler
+	ctx, rspMetadata := metadata.WithResponseMetadata(ctx)
+
 	// handle the req
 	rspbuf, err := s.opts.Handler.Handle(ctx, reqbuf)
 	if err != nil {
 		log.Errorf("server Handle error: %v", err)
 	}
 
-	response := addRspHeader(rspbuf, err)
+	response := addRspHeader(rspbuf, rspMetadata.Metadata(), err)
+
+	return s.encodeResponse(response)
+}
+
+// errorFrame builds the response frame of a request rejected without being handled
+func (s *serverTransport) errorFrame(err error) ([]byte, error) {
+	return s.encodeResponse(addRspHeader(nil, make(map[string][]byte), err))
+}
+
+func (s *serverTransport) encodeResponse(response *protocol.Response) ([]byte, error) {
+
+	serverCodec := codec.GetCodec(s.opts.Protocol)
 
 	// serialize the rsp
 	rspPb, err := proto.Marshal(response)
@@ -229,27 +578,31 @@ func (s *serverTransport) handle(ctx context.Context, frame []byte) ([]byte, err
 	return rspbody, nil
 }
 
-func addRspHeader(payload []byte, err error) *protocol.Response {
+func addRspHeader(payload []byte, md map[string][]byte, err error) *protocol.Response {
 	response := &protocol.Response{
-		Payload: payload,
-		RetCode: codes.OK,
-		RetMsg:  "success",
+		Payload:  payload,
+		RetCode:  uint32(codes.OK),
+		RetMsg:   "success",
+		Metadata: md,
 	}
 
 	if err != nil {
-		if e, ok := err.(*codes.Error); ok {
-			response.RetCode = e.Code
-			response.RetMsg = e.Message
-		} else {
-			response.RetCode = codes.ServerInternalErrorCode
-			response.RetMsg = codes.ServerInternalError.Message
+		code, msg, errMetadata := codes.ToResponse(err)
+		response.RetCode = code
+		response.RetMsg = msg
+		for k, v := range errMetadata {
+			response.Metadata[k] = v
 		}
 	}
 
 	return response
 }
 
-func (s *serverTransport) write(ctx context.Context, conn net.Conn, rsp []byte) error {
+func (s *serverTransport) write(ctx context.Context, conn *connWrapper, rsp []byte) error {
+	// responses of concurrent requests must not interleave
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+
 	if _, err := conn.Write(rsp); err != nil {
 		log.Errorf("conn Write err: %v", err)
 	}
@@ -257,9 +610,51 @@ func (s *serverTransport) write(ctx context.Context, conn net.Conn, rsp []byte)
 	return nil
 }
 
+// transportPanicCounter counts the panics recovered in the transport goroutines
+var transportPanicCounter = metrics.GetCounter("gorpc_server_transport_panics_total")
+
+// recoverPanic must be deferred by the transport goroutines, so that a panic is logged
+// instead of killing the whole server
+func recoverPanic() {
+	if p := recover(); p != nil {
+		log.Errorf("transport panic recovered: %v\n%s", p, debug.Stack())
+		transportPanicCounter.Inc()
+	}
+}
+
 type connWrapper struct {
 	net.Conn
-	framer Framer
+	framer  Framer
+	writeMu sync.Mutex
+	peeked  []byte // first byte of the next frame, read by waitFrame
+}
+
+// waitFrame waits until the first byte of the next frame arrives. A timeout at the deadline
+// leaves the connection between two frames
+func (c *connWrapper) waitFrame(deadline time.Time) error {
+	if len(c.peeked) > 0 {
+		return nil
+	}
+
+	c.SetReadDeadline(deadline)
+	defer c.SetReadDeadline(time.Time{})
+
+	b := make([]byte, 1)
+	n, err := c.Conn.Read(b)
+	if n == 1 {
+		c.peeked = b
+		return nil
+	}
+	return err
+}
+
+func (c *connWrapper) Read(b []byte) (int, error) {
+	if len(c.peeked) > 0 && len(b) > 0 {
+		n := copy(b, c.peeked)
+		c.peeked = c.peeked[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
 }
 
 func wrapConn(rawConn net.Conn) *connWrapper {
@@ -0,0 +1,258 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codec"
+	"github.com/HuaTug/My-RPC/codes"
+	connpool "github.com/HuaTug/My-RPC/pool"
+	"github.com/HuaTug/My-RPC/protocol"
+	"github.com/HuaTug/My-RPC/selector"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// delayHandler answers the request body after a delay
+type delayHandler time.Duration
+
+func (h delayHandler) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	time.Sleep(time.Duration(h))
+	return req, nil
+}
+
+// startTcpServer serves the accepted connections of a local listener with handleConn
+func startTcpServer(t *testing.T, opts *ServerTransportOptions) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+
+	opts.Network = "tcp"
+	if opts.Protocol == "" {
+		opts.Protocol = "proto"
+	}
+	s := &serverTransport{opts: opts}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go s.handleConn(ctx, wrapConn(conn))
+		}
+	}()
+
+	return lis.Addr().String()
+}
+
+func requestFrame(t *testing.T, streamID uint16, body string) []byte {
+	frame, err := codec.GetCodec("proto").Encode([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec.SetStreamID(frame, streamID)
+	return frame
+}
+
+// readFrames reads n frames, it returns the response body of each stream ID, or "goaway"
+func readFrames(t *testing.T, conn net.Conn, n int) map[uint16]string {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	framer := NewFramer()
+
+	frames := make(map[uint16]string)
+	for i := 0; i < n; i++ {
+		frame, err := framer.ReadFrame(conn)
+		if err != nil {
+			t.Fatalf("read frame %d: %v", i, err)
+		}
+		streamID := codec.GetStreamID(frame)
+		if codec.GetMsgType(frame) == codec.MsgTypeGoAway {
+			frames[streamID] = "goaway"
+			continue
+		}
+
+		rspbuf, err := codec.GetCodec("proto").Decode(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp := &protocol.Response{}
+		if err := proto.Unmarshal(rspbuf, rsp); err != nil {
+			t.Fatal(err)
+		}
+		frames[streamID] = string(rsp.Payload)
+	}
+	return frames
+}
+
+func TestMaxConnAgeKeepsFramesWhole(t *testing.T) {
+	addr := startTcpServer(t, &ServerTransportOptions{
+		Handler:    delayHandler(0),
+		MaxConnAge: 100 * time.Millisecond,
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the connection expires while the frame is being received
+	frame := requestFrame(t, 1, "hello")
+	conn.Write(frame[:len(frame)/2])
+	time.Sleep(200 * time.Millisecond)
+	conn.Write(frame[len(frame)/2:])
+
+	frames := readFrames(t, conn, 2)
+	if frames[1] != "hello" {
+		t.Errorf("response of stream 1 = %q, want %q", frames[1], "hello")
+	}
+	if frames[0] != "goaway" {
+		t.Errorf("no goaway frame after the connection expired, got %v", frames)
+	}
+}
+
+func TestDrainingConnRefusesRequests(t *testing.T) {
+	addr := startTcpServer(t, &ServerTransportOptions{
+		Handler:         delayHandler(100 * time.Millisecond),
+		MaxConnRequests: 1,
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the second request arrives while the first one is handled
+	conn.Write(append(requestFrame(t, 1, "first"), requestFrame(t, 2, "second")...))
+
+	frames := readFrames(t, conn, 3)
+	if frames[1] != "first" {
+		t.Errorf("response of stream 1 = %q, want %q", frames[1], "first")
+	}
+	if frames[2] != "goaway" {
+		t.Errorf("stream 2 answered with %q, want a goaway frame", frames[2])
+	}
+	if frames[0] != "goaway" {
+		t.Errorf("no goaway frame once the connection drained, got %v", frames)
+	}
+}
+
+// undecodableCodec fails to decode every frame
+type undecodableCodec struct {
+	codec.Codec
+}
+
+func (undecodableCodec) Decode([]byte) ([]byte, error) {
+	return nil, errors.New("undecodable frame")
+}
+
+func TestHandleErrorAnswersStream(t *testing.T) {
+	codec.RegisterCodec("undecodable", undecodableCodec{codec.GetCodec("proto")})
+	addr := startTcpServer(t, &ServerTransportOptions{
+		Handler:  delayHandler(0),
+		Protocol: "undecodable",
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write(requestFrame(t, 7, "hello"))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	frame, err := NewFramer().ReadFrame(conn)
+	if err != nil {
+		t.Fatalf("no response to the request which failed : %v", err)
+	}
+	if got := codec.GetStreamID(frame); got != 7 {
+		t.Errorf("stream ID = %d, want 7", got)
+	}
+	rsp := &protocol.Response{}
+	if err := proto.Unmarshal(frame[codec.FrameHeadLen:], rsp); err != nil {
+		t.Fatal(err)
+	}
+	if rsp.RetCode == uint32(codes.OK) {
+		t.Error("response of the request which failed is a success")
+	}
+}
+
+func TestClientRejectsResponseOfAnotherStream(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	// the server answers every request on another stream
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		frame, err := NewFramer().ReadFrame(conn)
+		if err != nil {
+			return
+		}
+		codec.SetStreamID(frame, codec.GetStreamID(frame)+1)
+		conn.Write(frame)
+		time.Sleep(time.Second)
+	}()
+
+	_, err = New().Send(context.Background(), requestFrame(t, 0, "hello"),
+		WithClientNetwork("tcp"),
+		WithClientTarget(lis.Addr().String()),
+		WithClientPool(connpool.NewConnPool()),
+		WithSelector(selector.DefaultSelector),
+		WithTimeout(5*time.Second),
+	)
+	if err != errStreamMismatch {
+		t.Errorf("Send() error = %v, want %v", err, errStreamMismatch)
+	}
+}
+
+func TestCancelFrameCancelsOnlyItsStream(t *testing.T) {
+	h := &blockingHandler{started: make(chan struct{}), cancelled: make(chan error, 1)}
+	addr := startTcpServer(t, &ServerTransportOptions{Handler: h})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write(requestFrame(t, 1, "block"))
+	<-h.started
+
+	cancelFrame, err := codec.NewCancelFrame(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write(cancelFrame)
+
+	select {
+	case err := <-h.cancelled:
+		if err != context.Canceled {
+			t.Errorf("handler context error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler context not cancelled by the cancel frame")
+	}
+
+	// the connection keeps serving the other streams, the cancelled one is not answered
+	conn.Write(requestFrame(t, 2, "next"))
+	if frames := readFrames(t, conn, 1); frames[2] != "next" {
+		t.Errorf("responses = %v, want only the response of stream 2", frames)
+	}
+}
@@ -2,36 +2,116 @@ package transport
 
 import (
 	"context"
-
 	"net"
+	"sync"
 	"time"
 
+	"github.com/HuaTug/My-RPC/codec"
+	"github.com/HuaTug/My-RPC/codes"
 	"github.com/HuaTug/My-RPC/log"
+	"github.com/HuaTug/My-RPC/peer"
 	"github.com/HuaTug/My-RPC/stream"
 )
 
+// maxPacketSize is the size of the largest udp datagram
+const maxPacketSize = 65536
+
+// defaultMaxPacketsInFlight bounds the packets being handled when ServerTransportOptions.MaxPacketsInFlight is not set
+const defaultMaxPacketsInFlight = 1024
+
+// defaultUdpDedupWindow is how long the responses are kept for the retransmitted requests when
+// ServerTransportOptions.UdpDedupWindow is not set
+const defaultUdpDedupWindow = 10 * time.Second
+
+// packetPool holds the buffers the udp packets are read into
+var packetPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, maxPacketSize)
+		return &buf
+	},
+}
+
 func (s *serverTransport) ListenAndServeUdp(ctx context.Context, opts ...ServerTransportOption) error {
 
-	conn, err := net.ListenPacket(s.opts.Network, s.opts.Address)
-	defer conn.Close()
+	// datagrams cannot be secured by a connection handshake
+	if s.opts.TransportAuth != nil {
+		return codes.NewFrameworkError(codes.Unimplemented, "transport auth not supported over udp")
+	}
 
-	buffer := make([]byte, 65536)
+	conn, err := net.ListenPacket(s.opts.Network, s.opts.Address)
 	if err != nil {
 		return err
 	}
 
+	readBufferSize := s.opts.ReadBufferSize
+	if readBufferSize <= 0 {
+		readBufferSize = defaultUdpReadBuffer
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		conn.Close()
+		return codes.NetworkNotSupportedError
+	}
+	if err := udpConn.SetReadBuffer(readBufferSize); err != nil {
+		conn.Close()
+		return err
+	}
+
+	window := s.opts.UdpDedupWindow
+	if window <= 0 {
+		window = defaultUdpDedupWindow
+	}
+	s.udp = newUdpRequests(window)
+
+	go func() {
+		if err := s.serveUdp(ctx, conn); err != nil {
+			log.Errorf("transport serve udp error, %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func (s *serverTransport) serveUdp(ctx context.Context, conn net.PacketConn) error {
+
+	defer conn.Close()
+
+	// unblock the pending read when the server stops
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	maxInFlight := s.opts.MaxPacketsInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMaxPacketsInFlight
+	}
+	// once maxInFlight packets are being handled, the next ones wait in the socket receive buffer
+	inFlight := make(chan struct{}, maxInFlight)
+
 	var tempDelay time.Duration
 
 	for {
-		// check upstream ctx is done
 		select {
+		case inFlight <- struct{}{}:
 		case <-ctx.Done():
-			return ctx.Err()
-		default:
+			return nil
 		}
 
-		num, addr, err := conn.ReadFrom(buffer)
+		buf := packetPool.Get().(*[]byte)
+		release := func() {
+			packetPool.Put(buf)
+			<-inFlight
+		}
+
+		num, addr, err := conn.ReadFrom(*buf)
 		if err != nil {
+			release()
+
+			// the connection is closed by the server stop
+			if ctx.Err() != nil {
+				return nil
+			}
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				if tempDelay == 0 {
 					tempDelay = 5 * time.Millisecond
@@ -46,33 +126,128 @@ func (s *serverTransport) ListenAndServeUdp(ctx context.Context, opts ...ServerT
 			}
 			return err
 		}
+		tempDelay = 0
 
-		req := buffer[:num]
+		if !s.filter.permitted(addrIP(addr)) {
+			log.Debugf("packet from %s denied", addr)
+			release()
+			continue
+		}
 
-		go func() {
+		req, key := s.readUdpRequest(conn, addr, (*buf)[:num])
+		if req == nil {
+			release()
+			continue
+		}
 
-			// build stream
-			ctx, _ := stream.NewServerStream(ctx)
+		reqCtx := peer.NewContext(withReceivedAt(ctx, time.Now()), &peer.Peer{Addr: addr, LocalAddr: conn.LocalAddr()})
 
-			if err := s.handleUdpConn(ctx, conn, addr, req); err != nil {
-				log.Errorf("gorpc handle udp conn error, %v", err)
-			}
+		s.dispatch(&task{
+			ctx: reqCtx,
+			run: func() {
+				defer release()
 
-		}()
+				// build stream
+				reqCtx, _ := stream.NewServerStream(reqCtx)
 
+				if err := s.handleUdpConn(reqCtx, conn, addr, key, req); err != nil {
+					log.Errorf("gorpc handle udp conn error, %v", err)
+				}
+			},
+			reject: func(err error) {
+				defer release()
+
+				rsp, err := s.errorFrame(err)
+				if err != nil {
+					log.Errorf("build error frame err, %v", err)
+					return
+				}
+				if err := s.writeUdpReject(conn, addr, key, rsp); err != nil {
+					log.Errorf("gorpc udp write error, %v", err)
+				}
+			},
+		})
 	}
+}
 
-	return nil
+// readUdpRequest returns the request frame of a packet, or nil when there is nothing to handle yet.
+// Legacy clients send bare frames, the others send the datagrams of their requests
+func (s *serverTransport) readUdpRequest(conn net.PacketConn, addr net.Addr, packet []byte) ([]byte, *udpKey) {
+	if len(packet) > 0 && packet[0] == codec.Magic {
+		return packet, nil
+	}
+
+	h, chunk, err := decodeDatagram(packet)
+	if err != nil {
+		log.Debugf("invalid datagram from %s", addr)
+		return nil, nil
+	}
+
+	key := udpKey{addr: addr.String(), requestID: h.requestID}
+	req, reply := s.udp.receive(key, h, chunk)
+
+	for _, datagram := range reply {
+		if _, err := conn.WriteTo(datagram, addr); err != nil {
+			log.Errorf("gorpc udp write error, %v", err)
+			break
+		}
+	}
+
+	return req, &key
+}
+
+// writeUdpResponse sends a response frame, as datagrams carrying the request ID when the request had one
+func (s *serverTransport) writeUdpResponse(conn net.PacketConn, addr net.Addr, key *udpKey, rsp []byte) error {
+	if key == nil {
+		_, err := conn.WriteTo(rsp, addr)
+		return err
+	}
+
+	datagrams, err := encodeDatagrams(key.requestID, rsp)
+	if err != nil {
+		s.udp.forget(*key)
+		return err
+	}
+	s.udp.respond(*key, datagrams)
+
+	return writeDatagrams(conn, addr, datagrams)
+}
+
+// writeUdpReject sends the error of a request rejected without being handled. The error is not
+// cached, so that a retransmission of the request is handled once the server has room for it
+func (s *serverTransport) writeUdpReject(conn net.PacketConn, addr net.Addr, key *udpKey, rsp []byte) error {
+	if key == nil {
+		_, err := conn.WriteTo(rsp, addr)
+		return err
+	}
+
+	s.udp.forget(*key)
+	datagrams, err := encodeDatagrams(key.requestID, rsp)
+	if err != nil {
+		return err
+	}
 
+	return writeDatagrams(conn, addr, datagrams)
 }
 
-func (s *serverTransport) handleUdpConn(ctx context.Context, conn net.PacketConn, addr net.Addr, req []byte) error {
+func writeDatagrams(conn net.PacketConn, addr net.Addr, datagrams [][]byte) error {
+	for _, datagram := range datagrams {
+		if _, err := conn.WriteTo(datagram, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *serverTransport) handleUdpConn(ctx context.Context, conn net.PacketConn, addr net.Addr, key *udpKey, req []byte) error {
 
 	rsp, err := s.handle(ctx, req)
 	if err != nil {
+		if key != nil {
+			s.udp.forget(*key)
+		}
 		return err
 	}
 
-	_, err = conn.WriteTo(rsp, addr)
-	return err
+	return s.writeUdpResponse(conn, addr, key, rsp)
 }
@@ -0,0 +1,157 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codec"
+	"github.com/HuaTug/My-RPC/protocol"
+	"github.com/HuaTug/My-RPC/selector"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// echoHandler answers the request body, it records how many requests it handles at once
+type echoHandler struct {
+	inflight    int32
+	maxInflight int32
+}
+
+func (h *echoHandler) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	n := atomic.AddInt32(&h.inflight, 1)
+	defer atomic.AddInt32(&h.inflight, -1)
+	for {
+		max := atomic.LoadInt32(&h.maxInflight)
+		if n <= max || atomic.CompareAndSwapInt32(&h.maxInflight, max, n) {
+			break
+		}
+	}
+
+	// keep the request buffer while other packets are read, a reused buffer would change it
+	body := append([]byte(nil), req...)
+	time.Sleep(2 * time.Millisecond)
+	if !bytes.Equal(body, req) {
+		return nil, fmt.Errorf("request buffer changed while being handled")
+	}
+	return req, nil
+}
+
+func startUdpServer(t *testing.T, handler Handler, maxInFlight int) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := newIPFilter(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &serverTransport{
+		opts: &ServerTransportOptions{
+			Network:            "udp",
+			Protocol:           "proto",
+			Handler:            handler,
+			MaxPacketsInFlight: maxInFlight,
+		},
+		filter: filter,
+		udp:    newUdpRequests(defaultUdpDedupWindow),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.serveUdp(ctx, conn)
+
+	return conn.LocalAddr().String()
+}
+
+func TestUdpServerConcurrentRequests(t *testing.T) {
+	const maxInFlight = 4
+	handler := &echoHandler{}
+	addr := startUdpServer(t, handler, maxInFlight)
+
+	clientCodec := codec.GetCodec("proto")
+	var wg sync.WaitGroup
+	errs := make(chan error, 32*20)
+	for c := 0; c < 32; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				// single and multi datagram requests
+				body := bytes.Repeat([]byte(fmt.Sprintf("%d-%d;", c, i)), 1+(c*i)%3000)
+
+				frame, err := clientCodec.Encode(body)
+				if err != nil {
+					errs <- err
+					return
+				}
+				rspFrame, err := New().Send(context.Background(), frame,
+					WithClientNetwork("udp"),
+					WithClientTarget(addr),
+					WithSelector(selector.DefaultSelector),
+					WithTimeout(10*time.Second),
+				)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				rspbuf, err := clientCodec.Decode(rspFrame)
+				if err != nil {
+					errs <- err
+					return
+				}
+				rsp := &protocol.Response{}
+				if err := proto.Unmarshal(rspbuf, rsp); err != nil {
+					errs <- err
+					return
+				}
+				if rsp.RetCode != 0 {
+					errs <- fmt.Errorf("request %d-%d failed: %s", c, i, rsp.RetMsg)
+				} else if !bytes.Equal(rsp.Payload, body) {
+					errs <- fmt.Errorf("request %d-%d got the response of another request", c, i)
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if max := atomic.LoadInt32(&handler.maxInflight); max > maxInFlight || max < 2 {
+		t.Errorf("%d requests handled at once, want concurrent requests up to %d", max, maxInFlight)
+	}
+}
+
+func TestUdpRejectIsNotCached(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := &serverTransport{udp: newUdpRequests(defaultUdpDedupWindow)}
+	key := udpKey{addr: conn.LocalAddr().String(), requestID: 1}
+	h := udpHeader{requestID: 1, count: 1}
+
+	if req, _ := s.udp.receive(key, h, []byte("request")); req == nil {
+		t.Fatal("request not handled")
+	}
+	if err := s.writeUdpReject(conn, conn.LocalAddr(), &key, []byte("overloaded")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the retransmission is handled instead of being answered with the error
+	req, reply := s.udp.receive(key, udpHeader{flags: udpFlagRetransmit, requestID: 1, count: 1}, []byte("request"))
+	if req == nil || reply != nil {
+		t.Errorf("retransmission = %q, %d datagrams, want the request handled again", req, len(reply))
+	}
+}
@@ -0,0 +1,533 @@
+package transport
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HuaTug/My-RPC/metrics"
+)
+
+// Over udp, every frame is sent as one or more datagrams starting with a datagram header :
+// [0] magic, [1] flags, [2:10] request ID, [10:12] fragment index, [12:14] fragment count.
+// The response carries the request ID of its request
+const (
+	udpMagic     = 0x12
+	udpHeaderLen = 14
+)
+
+// datagram flags
+const (
+	// the datagram acknowledges the fragments of the peer frame it received, its payload is
+	// the fragment count followed by a bitmap of the received fragments
+	udpFlagAck = 0x1
+	// the datagram is a retransmission, the server acknowledges the fragments it already has
+	udpFlagRetransmit = 0x2
+)
+
+// maxDatagramSize keeps the datagrams below the usual path MTU, larger frames are fragmented
+const maxDatagramSize = 1400
+
+// maxFragments bounds the size of a reassembled frame
+const maxFragments = 4096
+
+// bounds of the requests being reassembled, past them the oldest are dropped. A peer may hold
+// a few large frames, but never all the memory given to the reassembly
+const (
+	maxPartials            = 1024
+	maxPartialBytes        = 64 << 20
+	maxPartialsPerPeer     = 64
+	maxPartialBytesPerPeer = 16 << 20
+)
+
+// bounds of the responses cached for the dedup window, past them the oldest are dropped and
+// a retransmission of their request is handled again
+const (
+	maxResponses            = 4096
+	maxResponseBytes        = 64 << 20
+	maxResponsesPerPeer     = 1024
+	maxResponseBytesPerPeer = 16 << 20
+)
+
+var (
+	// udpPartialsDroppedCounter counts the requests dropped before all their fragments arrived
+	udpPartialsDroppedCounter = metrics.GetCounter("gorpc_server_udp_partials_dropped_total")
+	// udpResponsesDroppedCounter counts the responses dropped before the end of the dedup window
+	udpResponsesDroppedCounter = metrics.GetCounter("gorpc_server_udp_responses_dropped_total")
+)
+
+// minResendInterval bounds how often the server acknowledges a request or sends a response again,
+// a retransmission arrives as several datagrams but is answered once
+const minResendInterval = udpRetransmitInterval / 2
+
+var errInvalidDatagram = errors.New("invalid udp datagram")
+
+type udpHeader struct {
+	flags     uint8
+	requestID uint64
+	index     uint16
+	count     uint16
+}
+
+// udpRequestIDs generates the request IDs, they start at a random value so that
+// the IDs of a restarted client do not collide with the server dedup window
+var udpRequestIDs = func() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint64(b[:])
+}()
+
+func nextUdpRequestID() uint64 {
+	return atomic.AddUint64(&udpRequestIDs, 1)
+}
+
+func newDatagram(h udpHeader, payload []byte) []byte {
+	datagram := make([]byte, udpHeaderLen+len(payload))
+	datagram[0] = udpMagic
+	datagram[1] = h.flags
+	binary.BigEndian.PutUint64(datagram[2:10], h.requestID)
+	binary.BigEndian.PutUint16(datagram[10:12], h.index)
+	binary.BigEndian.PutUint16(datagram[12:14], h.count)
+	copy(datagram[udpHeaderLen:], payload)
+	return datagram
+}
+
+// encodeDatagrams splits a frame into datagrams of at most maxDatagramSize bytes
+func encodeDatagrams(requestID uint64, frame []byte) ([][]byte, error) {
+	chunkSize := maxDatagramSize - udpHeaderLen
+	count := (len(frame) + chunkSize - 1) / chunkSize
+	if count == 0 {
+		count = 1
+	}
+	if count > maxFragments {
+		return nil, errors.New("frame too large for udp")
+	}
+
+	datagrams := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		chunk := frame[i*chunkSize:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+
+		h := udpHeader{requestID: requestID, index: uint16(i), count: uint16(count)}
+		datagrams = append(datagrams, newDatagram(h, chunk))
+	}
+
+	return datagrams, nil
+}
+
+// encodeAck builds the datagram acknowledging the fragments received of the peer frame, f may be nil
+func encodeAck(requestID uint64, f *fragments) []byte {
+	var payload []byte
+	if f != nil {
+		payload = make([]byte, 2, 2+len(f.chunks)/8+1)
+		binary.BigEndian.PutUint16(payload, uint16(len(f.chunks)))
+		payload = append(payload, f.bitmap()...)
+	}
+
+	return newDatagram(udpHeader{flags: udpFlagAck, requestID: requestID, count: 1}, payload)
+}
+
+// fullAck builds the datagram acknowledging all the count fragments of the peer frame
+func fullAck(requestID uint64, count uint16) []byte {
+	f := &fragments{chunks: make([][]byte, count)}
+	for i := range f.chunks {
+		f.chunks[i] = []byte{}
+	}
+	return encodeAck(requestID, f)
+}
+
+// ackedFragments decodes the payload of an ack, it returns nil when it does not describe a frame of count fragments
+func ackedFragments(payload []byte, count int) []bool {
+	if len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != count || len(payload)-2 < (count+7)/8 {
+		return nil
+	}
+
+	acked := make([]bool, count)
+	for i := range acked {
+		acked[i] = payload[2+i/8]&(1<<(i%8)) != 0
+	}
+	return acked
+}
+
+// decodeDatagram returns the header and the payload of a datagram, the payload aliases the datagram
+func decodeDatagram(datagram []byte) (udpHeader, []byte, error) {
+	if len(datagram) < udpHeaderLen || datagram[0] != udpMagic {
+		return udpHeader{}, nil, errInvalidDatagram
+	}
+
+	h := udpHeader{
+		flags:     datagram[1],
+		requestID: binary.BigEndian.Uint64(datagram[2:10]),
+		index:     binary.BigEndian.Uint16(datagram[10:12]),
+		count:     binary.BigEndian.Uint16(datagram[12:14]),
+	}
+	if h.count == 0 || h.count > maxFragments || h.index >= h.count {
+		return udpHeader{}, nil, errInvalidDatagram
+	}
+
+	return h, datagram[udpHeaderLen:], nil
+}
+
+// missingDatagrams returns the datagrams whose fragment is not acknowledged, all of them when acked is nil.
+// A burst overflowing the receive buffer of the peer loses its last datagrams, so every other
+// retransmission is sent in reverse order
+func missingDatagrams(datagrams [][]byte, acked []bool, retransmission int) [][]byte {
+	missing := make([][]byte, 0, len(datagrams))
+	for i := range datagrams {
+		if retransmission%2 == 1 {
+			i = len(datagrams) - 1 - i
+		}
+		if acked == nil || !acked[i] {
+			missing = append(missing, datagrams[i])
+		}
+	}
+	return missing
+}
+
+// fragments reassembles the chunks of a frame, they may arrive in any order and more than once
+type fragments struct {
+	chunks   [][]byte
+	received int
+	size     int
+	created  time.Time
+	acked    time.Time     // when the received fragments were last acknowledged
+	elem     *list.Element // position in the reassembly order of the server
+}
+
+func newFragments(count uint16) *fragments {
+	return &fragments{
+		chunks:  make([][]byte, count),
+		created: time.Now(),
+	}
+}
+
+// add copies a chunk, it reports whether the frame is complete
+func (f *fragments) add(h udpHeader, chunk []byte) bool {
+	if int(h.count) != len(f.chunks) || f.chunks[h.index] != nil {
+		return f.complete()
+	}
+
+	f.chunks[h.index] = append(make([]byte, 0, len(chunk)), chunk...)
+	f.received++
+	f.size += len(chunk)
+
+	return f.complete()
+}
+
+func (f *fragments) complete() bool {
+	return f.received == len(f.chunks)
+}
+
+// bitmap returns a bitmap of the received fragments
+func (f *fragments) bitmap() []byte {
+	bitmap := make([]byte, (len(f.chunks)+7)/8)
+	for i, chunk := range f.chunks {
+		if chunk != nil {
+			bitmap[i/8] |= 1 << (i % 8)
+		}
+	}
+	return bitmap
+}
+
+// bytes returns the reassembled frame
+func (f *fragments) bytes() []byte {
+	frame := make([]byte, 0, f.size)
+	for _, chunk := range f.chunks {
+		frame = append(frame, chunk...)
+	}
+	return frame
+}
+
+type udpKey struct {
+	addr      string
+	requestID uint64
+}
+
+// udpRequests reassembles the fragmented requests and suppresses the retransmitted ones : within
+// the dedup window a retransmitted request is not handled again, its cached response is sent instead
+type udpRequests struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	partials  map[udpKey]*fragments
+	order     *list.List           // keys of the partials, oldest first
+	usage     map[string]*udpUsage // usage of the partials per peer address
+	total     udpUsage             // usage of all the partials
+	handled   map[udpKey]*udpResponse
+	responses *list.List           // keys of the cached responses, oldest first
+	rspUsage  map[string]*udpUsage // usage of the cached responses per peer address
+	rspTotal  udpUsage             // usage of all the cached responses
+	lastSweep time.Time
+}
+
+// udpUsage is the number and the bytes of requests being reassembled, or of cached responses
+type udpUsage struct {
+	count int
+	size  int
+}
+
+type udpResponse struct {
+	count     uint16        // fragment count of the request
+	datagrams [][]byte      // nil while the request is being handled
+	size      int           // bytes of the datagrams
+	expires   time.Time     // zero while the request is being handled
+	resent    int           // how many times the response was sent again
+	sent      time.Time     // when the response or an ack was last sent
+	elem      *list.Element // position in the response order of the server, nil while the request is being handled
+}
+
+func newUdpRequests(window time.Duration) *udpRequests {
+	return &udpRequests{
+		window:    window,
+		partials:  make(map[udpKey]*fragments),
+		order:     list.New(),
+		usage:     make(map[string]*udpUsage),
+		handled:   make(map[udpKey]*udpResponse),
+		responses: list.New(),
+		rspUsage:  make(map[string]*udpUsage),
+		lastSweep: time.Now(),
+	}
+}
+
+// receive processes a datagram of a request. It returns the request once complete and not handled
+// yet, and the datagrams to send back right away : acks of the received fragments of a retransmitted
+// request, or the datagrams of the cached response the client misses
+func (r *udpRequests) receive(key udpKey, h udpHeader, payload []byte) (req []byte, reply [][]byte) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sweep(now)
+
+	if rsp, ok := r.handled[key]; ok {
+		return nil, rsp.reply(key, h, payload, now)
+	}
+
+	// an ack of a response which is not cached anymore
+	if h.flags&udpFlagAck != 0 {
+		return nil, nil
+	}
+
+	if h.count == 1 {
+		req = payload
+	} else {
+		f, ok := r.partials[key]
+		if !ok {
+			f = newFragments(h.count)
+			r.addPartial(key, f)
+		}
+		size := f.size
+		complete := f.add(h, payload)
+		r.grow(key.addr, f.size-size)
+		if !complete {
+			// the request itself may be the oldest one dropped
+			if r.evict(key.addr); r.partials[key] != f {
+				return nil, nil
+			}
+			if h.flags&udpFlagRetransmit != 0 && now.Sub(f.acked) >= minResendInterval {
+				f.acked = now
+				return nil, [][]byte{encodeAck(key.requestID, f)}
+			}
+			return nil, nil
+		}
+		r.removePartial(key)
+		req = f.bytes()
+	}
+
+	r.handled[key] = &udpResponse{count: h.count}
+	return req, nil
+}
+
+func (r *udpRequests) addPartial(key udpKey, f *fragments) {
+	r.partials[key] = f
+	f.elem = r.order.PushBack(key)
+
+	u, ok := r.usage[key.addr]
+	if !ok {
+		u = &udpUsage{}
+		r.usage[key.addr] = u
+	}
+	u.count++
+	r.total.count++
+}
+
+// grow accounts n more bytes received by a partial of the peer
+func (r *udpRequests) grow(addr string, n int) {
+	r.usage[addr].size += n
+	r.total.size += n
+}
+
+func (r *udpRequests) removePartial(key udpKey) {
+	f, ok := r.partials[key]
+	if !ok {
+		return
+	}
+	delete(r.partials, key)
+	r.order.Remove(f.elem)
+
+	u := r.usage[key.addr]
+	u.count--
+	u.size -= f.size
+	if u.count == 0 {
+		delete(r.usage, key.addr)
+	}
+	r.total.count--
+	r.total.size -= f.size
+}
+
+// evict drops the oldest partials of the peer while it is over its bounds, then the oldest
+// partials of all the peers while the server is over its bounds
+func (r *udpRequests) evict(addr string) {
+	for u := r.usage[addr]; u != nil && (u.count > maxPartialsPerPeer || u.size > maxPartialBytesPerPeer); u = r.usage[addr] {
+		for e := r.order.Front(); e != nil; e = e.Next() {
+			if key := e.Value.(udpKey); key.addr == addr {
+				r.removePartial(key)
+				udpPartialsDroppedCounter.Inc()
+				break
+			}
+		}
+	}
+
+	for r.total.count > maxPartials || r.total.size > maxPartialBytes {
+		r.removePartial(r.order.Front().Value.(udpKey))
+		udpPartialsDroppedCounter.Inc()
+	}
+}
+
+// reply returns the datagrams answering a datagram of a request already received
+func (rsp *udpResponse) reply(key udpKey, h udpHeader, payload []byte, now time.Time) [][]byte {
+	if now.Sub(rsp.sent) < minResendInterval {
+		return nil
+	}
+
+	// the request is being handled, the client stops retransmitting it once acknowledged
+	if rsp.datagrams == nil {
+		if h.flags&udpFlagRetransmit == 0 {
+			return nil
+		}
+		rsp.sent = now
+		return [][]byte{fullAck(key.requestID, rsp.count)}
+	}
+
+	var acked []bool
+	if h.flags&udpFlagAck != 0 {
+		acked = ackedFragments(payload, len(rsp.datagrams))
+	}
+
+	rsp.resent++
+	rsp.sent = now
+	return missingDatagrams(rsp.datagrams, acked, rsp.resent)
+}
+
+// respond caches the response of a request for the dedup window
+func (r *udpRequests) respond(key udpKey, datagrams [][]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.removeResponse(key)
+
+	rsp := &udpResponse{datagrams: datagrams, expires: now.Add(r.window), sent: now}
+	for _, datagram := range datagrams {
+		rsp.size += len(datagram)
+	}
+	r.addResponse(key, rsp)
+	r.evictResponses(key.addr)
+}
+
+// forget drops a request which got no response, or whose response must not be sent again
+// from the cache, so that its retransmission is handled
+func (r *udpRequests) forget(key udpKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removeResponse(key)
+}
+
+func (r *udpRequests) addResponse(key udpKey, rsp *udpResponse) {
+	r.handled[key] = rsp
+	rsp.elem = r.responses.PushBack(key)
+
+	u, ok := r.rspUsage[key.addr]
+	if !ok {
+		u = &udpUsage{}
+		r.rspUsage[key.addr] = u
+	}
+	u.count++
+	u.size += rsp.size
+	r.rspTotal.count++
+	r.rspTotal.size += rsp.size
+}
+
+// removeResponse drops a request, with its cached response if it has one
+func (r *udpRequests) removeResponse(key udpKey) {
+	rsp, ok := r.handled[key]
+	if !ok {
+		return
+	}
+	delete(r.handled, key)
+	if rsp.elem == nil {
+		return
+	}
+	r.responses.Remove(rsp.elem)
+
+	u := r.rspUsage[key.addr]
+	u.count--
+	u.size -= rsp.size
+	if u.count == 0 {
+		delete(r.rspUsage, key.addr)
+	}
+	r.rspTotal.count--
+	r.rspTotal.size -= rsp.size
+}
+
+// evictResponses drops the oldest responses of the peer while it is over its bounds, then the
+// oldest responses of all the peers while the server is over its bounds
+func (r *udpRequests) evictResponses(addr string) {
+	for u := r.rspUsage[addr]; u != nil && (u.count > maxResponsesPerPeer || u.size > maxResponseBytesPerPeer); u = r.rspUsage[addr] {
+		for e := r.responses.Front(); e != nil; e = e.Next() {
+			if key := e.Value.(udpKey); key.addr == addr {
+				r.removeResponse(key)
+				udpResponsesDroppedCounter.Inc()
+				break
+			}
+		}
+	}
+
+	for r.rspTotal.count > maxResponses || r.rspTotal.size > maxResponseBytes {
+		r.removeResponse(r.responses.Front().Value.(udpKey))
+		udpResponsesDroppedCounter.Inc()
+	}
+}
+
+// sweep drops the expired responses and the requests whose fragments did not all arrive within the window
+func (r *udpRequests) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < r.window/2 {
+		return
+	}
+	r.lastSweep = now
+
+	// the responses are in expiry order
+	for e := r.responses.Front(); e != nil; e = r.responses.Front() {
+		key := e.Value.(udpKey)
+		if !now.After(r.handled[key].expires) {
+			break
+		}
+		r.removeResponse(key)
+	}
+	// the partials are in creation order
+	for e := r.order.Front(); e != nil; e = r.order.Front() {
+		key := e.Value.(udpKey)
+		if now.Sub(r.partials[key].created) <= r.window {
+			break
+		}
+		r.removePartial(key)
+	}
+}
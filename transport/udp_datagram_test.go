@@ -0,0 +1,166 @@
+package transport
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// receiveFirst receives the first fragment of a request of count fragments
+func receiveFirst(r *udpRequests, key udpKey, count uint16) {
+	r.receive(key, udpHeader{requestID: key.requestID, count: count}, []byte("chunk"))
+}
+
+func TestUdpPartialsPerPeerBound(t *testing.T) {
+	r := newUdpRequests(time.Minute)
+
+	for id := uint64(0); id < maxPartialsPerPeer+10; id++ {
+		receiveFirst(r, udpKey{addr: "peer-a", requestID: id}, 2)
+	}
+	receiveFirst(r, udpKey{addr: "peer-b", requestID: 0}, 2)
+
+	if got := r.usage["peer-a"].count; got != maxPartialsPerPeer {
+		t.Errorf("peer-a partials = %d, want %d", got, maxPartialsPerPeer)
+	}
+	for id := uint64(0); id < 10; id++ {
+		if _, ok := r.partials[udpKey{addr: "peer-a", requestID: id}]; ok {
+			t.Errorf("oldest partial %d kept", id)
+		}
+	}
+	if _, ok := r.partials[udpKey{addr: "peer-b", requestID: 0}]; !ok {
+		t.Error("partial of another peer dropped")
+	}
+}
+
+func TestUdpPartialsGlobalBound(t *testing.T) {
+	r := newUdpRequests(time.Minute)
+
+	for i := 0; i < maxPartials+5; i++ {
+		receiveFirst(r, udpKey{addr: fmt.Sprintf("peer-%d", i)}, 2)
+	}
+
+	if r.total.count != maxPartials || len(r.partials) != maxPartials {
+		t.Errorf("partials = %d, want %d", len(r.partials), maxPartials)
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok := r.partials[udpKey{addr: fmt.Sprintf("peer-%d", i)}]; ok {
+			t.Errorf("oldest partial of peer-%d kept", i)
+		}
+	}
+}
+
+func TestUdpPartialsBytesBound(t *testing.T) {
+	r := newUdpRequests(time.Minute)
+	chunk := make([]byte, maxDatagramSize-udpHeaderLen)
+
+	// every request misses its last fragment
+	requests := maxPartialBytesPerPeer/(len(chunk)*(maxFragments-1)) + 1
+	for id := 0; id < requests; id++ {
+		for i := 0; i < maxFragments-1; i++ {
+			h := udpHeader{requestID: uint64(id), index: uint16(i), count: maxFragments}
+			r.receive(udpKey{addr: "peer-a", requestID: uint64(id)}, h, chunk)
+		}
+	}
+
+	u := r.usage["peer-a"]
+	if u.size > maxPartialBytesPerPeer {
+		t.Errorf("peer-a partial bytes = %d, want at most %d", u.size, maxPartialBytesPerPeer)
+	}
+	if u.count != requests-1 {
+		t.Errorf("peer-a partials = %d, want %d", u.count, requests-1)
+	}
+	if _, ok := r.partials[udpKey{addr: "peer-a", requestID: 0}]; ok {
+		t.Error("oldest partial kept")
+	}
+}
+
+func TestUdpPartialsReleasedOnCompletion(t *testing.T) {
+	r := newUdpRequests(time.Minute)
+	key := udpKey{addr: "peer-a", requestID: 1}
+
+	r.receive(key, udpHeader{requestID: 1, index: 1, count: 2}, []byte("world"))
+	req, _ := r.receive(key, udpHeader{requestID: 1, index: 0, count: 2}, []byte("hello "))
+
+	if string(req) != "hello world" {
+		t.Errorf("request = %q, want %q", req, "hello world")
+	}
+	if len(r.partials) != 0 || r.order.Len() != 0 || len(r.usage) != 0 || r.total != (udpUsage{}) {
+		t.Errorf("partials not released : %d partials, usage %+v", len(r.partials), r.total)
+	}
+}
+
+// handle receives a single datagram request and caches its response
+func handle(r *udpRequests, key udpKey, rsp []byte) {
+	r.receive(key, udpHeader{requestID: key.requestID, count: 1}, []byte("request"))
+	r.respond(key, [][]byte{rsp})
+}
+
+func TestUdpResponsesPerPeerBound(t *testing.T) {
+	r := newUdpRequests(time.Minute)
+
+	for id := uint64(0); id < maxResponsesPerPeer+10; id++ {
+		handle(r, udpKey{addr: "peer-a", requestID: id}, []byte("response"))
+	}
+	handle(r, udpKey{addr: "peer-b", requestID: 0}, []byte("response"))
+
+	if got := r.rspUsage["peer-a"].count; got != maxResponsesPerPeer {
+		t.Errorf("peer-a responses = %d, want %d", got, maxResponsesPerPeer)
+	}
+	for id := uint64(0); id < 10; id++ {
+		if _, ok := r.handled[udpKey{addr: "peer-a", requestID: id}]; ok {
+			t.Errorf("oldest response %d kept", id)
+		}
+	}
+	if _, ok := r.handled[udpKey{addr: "peer-b", requestID: 0}]; !ok {
+		t.Error("response of another peer dropped")
+	}
+}
+
+func TestUdpResponsesGlobalBound(t *testing.T) {
+	r := newUdpRequests(time.Minute)
+
+	for i := 0; i < maxResponses+5; i++ {
+		handle(r, udpKey{addr: fmt.Sprintf("peer-%d", i)}, []byte("response"))
+	}
+
+	if r.rspTotal.count != maxResponses || len(r.handled) != maxResponses {
+		t.Errorf("responses = %d, want %d", len(r.handled), maxResponses)
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok := r.handled[udpKey{addr: fmt.Sprintf("peer-%d", i)}]; ok {
+			t.Errorf("oldest response of peer-%d kept", i)
+		}
+	}
+}
+
+func TestUdpResponsesBytesBound(t *testing.T) {
+	r := newUdpRequests(time.Minute)
+	rsp := make([]byte, 1<<20)
+
+	for id := uint64(0); id < maxResponseBytesPerPeer>>20+1; id++ {
+		handle(r, udpKey{addr: "peer-a", requestID: id}, rsp)
+	}
+
+	if u := r.rspUsage["peer-a"]; u.size > maxResponseBytesPerPeer {
+		t.Errorf("peer-a response bytes = %d, want at most %d", u.size, maxResponseBytesPerPeer)
+	}
+	if _, ok := r.handled[udpKey{addr: "peer-a", requestID: 0}]; ok {
+		t.Error("oldest response kept")
+	}
+}
+
+func TestUdpResponsesExpire(t *testing.T) {
+	r := newUdpRequests(10 * time.Millisecond)
+	key := udpKey{addr: "peer-a", requestID: 1}
+	handle(r, key, []byte("response"))
+
+	time.Sleep(20 * time.Millisecond)
+	req, _ := r.receive(key, udpHeader{requestID: 1, count: 1}, []byte("request"))
+
+	if string(req) != "request" {
+		t.Errorf("request = %q, want the retransmission handled once the response expired", req)
+	}
+	if r.responses.Len() != 0 || len(r.rspUsage) != 0 || r.rspTotal != (udpUsage{}) {
+		t.Errorf("responses not released : %d responses, usage %+v", r.responses.Len(), r.rspTotal)
+	}
+}
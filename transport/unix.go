@@ -0,0 +1,220 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/HuaTug/My-RPC/log"
+)
+
+// staleSocketDialTimeout bounds the dial telling a stale socket file from a socket still in use
+const staleSocketDialTimeout = 100 * time.Millisecond
+
+// maxSeqPacketSize is the size of the largest unixpacket message, larger writes are split
+// as a message must fit in the socket send buffer
+const maxSeqPacketSize = 64 * 1024
+
+// SplitUnixTarget splits a target like unix:///run/app.sock or unixpacket:///run/app.sock into
+// its network and socket path, ok is false for any other target
+func SplitUnixTarget(target string) (network string, path string, ok bool) {
+	for _, network := range []string{"unix", "unixpacket"} {
+		if path := strings.TrimPrefix(target, network+"://"); path != target {
+			return network, path, true
+		}
+	}
+	return "", "", false
+}
+
+func isUnixNetwork(network string) bool {
+	return network == "unix" || network == "unixpacket"
+}
+
+func (s *serverTransport) ListenAndServeUnix(ctx context.Context, opts ...ServerTransportOption) error {
+
+	path := s.opts.Address
+	if _, p, ok := SplitUnixTarget(path); ok {
+		path = p
+	}
+
+	if err := removeStaleSocket(s.opts.Network, path); err != nil {
+		return err
+	}
+
+	lis, err := net.Listen(s.opts.Network, path)
+	if err != nil {
+		return err
+	}
+
+	if err := setSocketPermissions(path, s.opts.SocketMode, s.opts.SocketGroup); err != nil {
+		lis.Close()
+		return err
+	}
+
+	// closing the listener removes the socket file
+	go func() {
+		<-ctx.Done()
+		lis.Close()
+	}()
+
+	go func() {
+		if err := s.serve(ctx, lis); err != nil {
+			log.Errorf("transport serve unix error, %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// removeStaleSocket removes the socket file left by a server which did not stop cleanly. Only a
+// socket refusing connections is stale, any other dial failure, e.g. : a timeout of a busy server,
+// keeps the file. Files which are not sockets are kept too
+func removeStaleSocket(network string, path string) error {
+	// abstract sockets have no file
+	if strings.HasPrefix(path, "@") {
+		return nil
+	}
+
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout(network, path, staleSocketDialTimeout)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("%s may be in use by another server: %v", path, err)
+	}
+
+	log.Infof("removing stale socket %s", path)
+	return os.Remove(path)
+}
+
+// setSocketPermissions sets the mode and the group of the socket file, a zero mode and an
+// empty group keep the ones the file was created with
+func setSocketPermissions(path string, mode os.FileMode, group string) error {
+	if strings.HasPrefix(path, "@") {
+		return nil
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+
+	if group != "" {
+		gid, err := strconv.Atoi(group)
+		if err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return err
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return err
+			}
+		}
+		if err := os.Chown(path, -1, gid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PeerCred holds the credentials of the process at the other end of a unix connection
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+type peerCredKey struct{}
+
+func withPeerCred(ctx context.Context, cred *PeerCred) context.Context {
+	return context.WithValue(ctx, peerCredKey{}, cred)
+}
+
+// PeerCredFromContext returns the credentials of the client process of a request received
+// on a unix socket, ok is false for the other networks and where they are not supported
+func PeerCredFromContext(ctx context.Context) (*PeerCred, bool) {
+	cred, ok := ctx.Value(peerCredKey{}).(*PeerCred)
+	return cred, ok
+}
+
+var errPeerCredNotSupported = errors.New("peer credentials are not supported on this platform")
+
+// seqPacketConn carries the byte stream of the frames over a unixpacket connection. Each read
+// returns at most one message and drops what does not fit in the buffer, so messages are read
+// whole and handed out as a stream, and writes are split into messages of maxSeqPacketSize bytes
+type seqPacketConn struct {
+	net.Conn
+	buf    []byte
+	unread []byte
+}
+
+func newSeqPacketConn(conn net.Conn) *seqPacketConn {
+	return &seqPacketConn{
+		Conn: conn,
+		buf:  make([]byte, maxSeqPacketSize),
+	}
+}
+
+func (c *seqPacketConn) Read(b []byte) (int, error) {
+	if len(c.unread) == 0 {
+		n, err := c.Conn.Read(c.buf)
+		if n == 0 {
+			return 0, err
+		}
+		c.unread = c.buf[:n]
+	}
+
+	n := copy(b, c.unread)
+	c.unread = c.unread[n:]
+	return n, nil
+}
+
+func (c *seqPacketConn) Write(b []byte) (int, error) {
+	var written int
+	for written < len(b) {
+		end := written + maxSeqPacketSize
+		if end > len(b) {
+			end = len(b)
+		}
+
+		n, err := c.Conn.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// CloseWrite half-closes the connection
+func (c *seqPacketConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
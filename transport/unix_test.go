@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveStaleSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	// a server which did not stop cleanly leaves its socket file
+	stale := filepath.Join(dir, "stale.sock")
+	lis, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis.(*net.UnixListener).SetUnlinkOnClose(false)
+	lis.Close()
+
+	if err := removeStaleSocket("unix", stale); err != nil {
+		t.Errorf("removeStaleSocket() of a stale socket = %v", err)
+	}
+	if _, err := os.Lstat(stale); !os.IsNotExist(err) {
+		t.Error("stale socket kept")
+	}
+
+	inUse := filepath.Join(dir, "in-use.sock")
+	lis, err = net.Listen("unix", inUse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	if err := removeStaleSocket("unix", inUse); err == nil {
+		t.Error("removeStaleSocket() of a socket in use = nil, want an error")
+	}
+	// the dial fails without being refused, e.g. : a socket of another type
+	if err := removeStaleSocket("unixgram", inUse); err == nil {
+		t.Error("removeStaleSocket() of a socket which did not refuse the dial = nil, want an error")
+	}
+	if _, err := os.Lstat(inUse); err != nil {
+		t.Errorf("socket in use removed: %v", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStaleSocket("unix", file); err == nil {
+		t.Error("removeStaleSocket() of a regular file = nil, want an error")
+	}
+
+	if err := removeStaleSocket("unix", filepath.Join(dir, "missing.sock")); err != nil {
+		t.Errorf("removeStaleSocket() of a missing file = %v", err)
+	}
+}
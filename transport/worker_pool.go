@@ -0,0 +1,152 @@
+package transport
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/HuaTug/My-RPC/codes"
+	"github.com/HuaTug/My-RPC/metrics"
+)
+
+var (
+	queueDepthGauge      = metrics.GetGauge("gorpc_server_queue_depth")
+	queueRejectedCounter = metrics.GetCounter("gorpc_server_queue_rejected_total")
+	queueDroppedCounter  = metrics.GetCounter("gorpc_server_queue_dropped_total")
+)
+
+// workerPool runs the requests on a bounded number of goroutines, the requests waiting
+// for a worker are kept in a bounded queue
+type workerPool struct {
+	tasks   chan *task
+	maxWait time.Duration // requests waiting longer are dropped
+
+	mu     sync.Mutex
+	closed error // set once the server stops, the requests submitted afterwards are rejected with it
+}
+
+type task struct {
+	ctx      context.Context
+	enqueued time.Time
+	run      func()          // handles the request
+	reject   func(err error) // answers the request with an error without handling it
+}
+
+func newWorkerPool(ctx context.Context, workers int, queueSize int, maxWait time.Duration) *workerPool {
+	p := &workerPool{
+		tasks:   make(chan *task, queueSize),
+		maxWait: maxWait,
+	}
+
+	for i := 0; i < workers; i++ {
+		go p.work(ctx)
+	}
+	go p.close(ctx)
+
+	return p
+}
+
+// submit queues a request, it is rejected with codes.ServerOverloadError when the queue is full
+func (p *workerPool) submit(t *task) {
+	t.enqueued = time.Now()
+
+	p.mu.Lock()
+	if err := p.closed; err != nil {
+		p.mu.Unlock()
+		t.reject(err)
+		return
+	}
+
+	select {
+	case p.tasks <- t:
+		queueDepthGauge.Add(1)
+		p.mu.Unlock()
+	default:
+		p.mu.Unlock()
+		queueRejectedCounter.Inc()
+		t.reject(codes.ServerOverloadError)
+	}
+}
+
+// close stops accepting requests once the server stops and rejects the queued ones. The queue
+// is only drained after it is closed, so that no request is left in it without being answered
+func (p *workerPool) close(ctx context.Context) {
+	<-ctx.Done()
+
+	p.mu.Lock()
+	p.closed = ctx.Err()
+	p.mu.Unlock()
+
+	p.drain(p.closed)
+}
+
+func (p *workerPool) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-p.tasks:
+			queueDepthGauge.Add(-1)
+			p.execute(t)
+		}
+	}
+}
+
+func (p *workerPool) execute(t *task) {
+	defer recoverPanic()
+
+	// the caller cancelled the request while it was queued
+	if err := t.ctx.Err(); err != nil {
+		queueDroppedCounter.Inc()
+		t.reject(err)
+		return
+	}
+
+	if p.maxWait > 0 && time.Since(t.enqueued) > p.maxWait {
+		queueDroppedCounter.Inc()
+		t.reject(codes.DeadlineExceededError)
+		return
+	}
+
+	t.run()
+}
+
+// drain rejects the queued requests once the server stops
+func (p *workerPool) drain(err error) {
+	for {
+		select {
+		case t := <-p.tasks:
+			queueDepthGauge.Add(-1)
+			t.reject(err)
+		default:
+			return
+		}
+	}
+}
+
+type receivedAtKey struct{}
+
+// withReceivedAt records when a request was read from the network
+func withReceivedAt(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, receivedAtKey{}, t)
+}
+
+// ReceivedAt returns when the request of the context was read from the network. Deadlines are
+// counted from it, so that the time spent waiting for a worker is accounted for
+func ReceivedAt(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(receivedAtKey{}).(time.Time)
+	return t, ok
+}
+
+// dispatch runs a request on the worker pool when one is configured, or on a new goroutine
+func (s *serverTransport) dispatch(t *task) {
+	if s.pool != nil {
+		s.pool.submit(t)
+		return
+	}
+
+	go func() {
+		defer recoverPanic()
+		t.run()
+	}()
+}
@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSubmitAfterStopIsRejected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := newWorkerPool(ctx, 1, 8, 0)
+	cancel()
+
+	// wait for the pool to close, the queue still has room
+	deadline := time.Now().Add(time.Second)
+	for {
+		p.mu.Lock()
+		closed := p.closed
+		p.mu.Unlock()
+		if closed != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("pool not closed after the server stopped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rejected := make(chan error, 1)
+	p.submit(&task{
+		ctx:    context.Background(),
+		run:    func() { t.Error("task run after the server stopped") },
+		reject: func(err error) { rejected <- err },
+	})
+
+	select {
+	case err := <-rejected:
+		if err != context.Canceled {
+			t.Errorf("rejected with %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("task submitted after the server stopped neither run nor rejected")
+	}
+}
+
+func TestQueuedTasksRejectedOnStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// no worker, the tasks stay queued until the pool closes
+	p := newWorkerPool(ctx, 0, 8, 0)
+
+	rejected := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		p.submit(&task{
+			ctx:    context.Background(),
+			run:    func() { t.Error("task run without worker") },
+			reject: func(err error) { rejected <- err },
+		})
+	}
+	cancel()
+
+	for i := 0; i < 4; i++ {
+		select {
+		case <-rejected:
+		case <-time.After(time.Second):
+			t.Fatalf("%d queued tasks rejected, want 4", i)
+		}
+	}
+}